@@ -11,100 +11,126 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	// internal packages
 	"github.com/tmedwards/tweego/internal/option"
+	"github.com/tmedwards/tweego/pkg/twee"
 	// external packages
 	"github.com/paulrosania/go-charset/charset"
 )
 
-type outputMode int
-
-const (
-	outModeHTML outputMode = iota
-	outModeTwee3
-	outModeTwee1
-	outModeTwine2Archive
-	outModeTwine1Archive
-)
-
-type common struct {
-	formatID  string // ID of the story format to use
-	startName string // name of the starting passage
-}
-
 type config struct {
-	cmdline common
-	common
+	formatID         string // ID of the story format to use
+	proofingFormatID string // ID or name of the proofing format to use
+	startName        string // name of the starting passage
 
-	encoding    string     // input encoding
-	sourcePaths []string   // slice of paths to seach for source files
-	modulePaths []string   // slice of paths to seach for module files
-	headFile    string     // name of the head file
-	outFile     string     // name of the output file
-	outMode     outputMode // output mode
+	diagFormat   string          // diagnostics output format
+	encoding     string          // input encoding
+	excludePaths []string        // slice of paths to exclude from the source files
+	excludeTags  []string        // slice of tags whose passages are excluded from the story
+	exemptTags   []string        // slice of tags exempting passages from the reachability reports
+	profile      string          // name of the project configuration file profile
+	sourcePaths  []string        // slice of paths to seach for source files
+	modulePaths  []string        // slice of paths to seach for module files
+	headFile     string          // name of the head file
+	outFile      string          // name of the output file
+	outMode      twee.OutputMode // output mode
+	splitBy      string          // decompile into a project tree, grouping passages per the split mode
+	storyName    string          // name or IFID of the story to load from library archives
 
-	formats     storyFormatsMap // map of all enumerated story formats
-	logFiles    bool            // log input files
-	logStats    bool            // log story statistics
-	testMode    bool            // enable test mode
-	trim        bool            // enable passage trimming
-	twee2Compat bool            // enable Twee2 header extension compatibility mode
-	watchFiles  bool            // enable filesystem watching
+	formats     twee.Formats // map of all enumerated story formats
+	allStories  bool         // decompile each story of the library archives
+	listStories bool         // list the stories of the library archives
+	logFiles    bool         // log input files
+	logStats    bool         // log story statistics
+	serveAddr   string       // address of the live-reload development server
+	serveFiles  bool         // enable the live-reload development server
+	strictLinks bool         // treat broken passage links as errors
+	strictLock  bool         // treat story format lockfile mismatches as errors
+	testMode    bool         // enable test mode
+	trim        bool         // enable passage trimming
+	twee2Compat bool         // enable Twee2 header extension compatibility mode
+	updateLock  bool         // record the resolved story format within the lockfile
+	watchFiles  bool         // enable filesystem watching
+	watchOpts   watchOptions // filesystem watching options
+
+	formatDiags []*twee.Diagnostic // diagnostics found while enumerating the story formats, held until the diagnostics format is known
 }
 
 const (
-	defaultFormatID  = "sugarcube-2"
 	defaultOutFile   = "-" // <stdout>
-	defaultOutMode   = outModeHTML
-	defaultStartName = "Start"
+	defaultOutMode   = twee.OutModeHTML
 	defaultTrimState = true
 )
 
-// newConfig creates a new config instance
-func newConfig() *config {
-	// Get the base paths to search for story formats.
-	formatDirs := (func() []string {
-		var (
-			baseDirnames = []string{
-				"storyformats",
-				".storyformats",
-				"story-formats", // DEPRECATED
-				"storyFormats",  // DEPRECATED
-				"targets",       // DEPRECATED
-			}
-			basePaths      = []string{programDir}
-			searchDirnames []string
-		)
-		if homeDir, err := userHomeDir(); err == nil {
-			if !stringSliceContains(basePaths, homeDir) {
-				basePaths = append(basePaths, homeDir)
-			}
-		}
-		if !stringSliceContains(basePaths, workingDir) {
-			basePaths = append(basePaths, workingDir)
+// Base names of the directories to search for story formats, in order.
+var formatDirBasenames = []string{
+	"storyformats",
+	".storyformats",
+	"story-formats", // DEPRECATED
+	"storyFormats",  // DEPRECATED
+	"targets",       // DEPRECATED
+}
+
+// getFormatSearchDirs returns the directories to search for story formats, in
+// order—i.e., those within the program, home, and working directories, then
+// those from the `TWEEGO_PATH` environment variable.
+func getFormatSearchDirs() []string {
+	var (
+		basePaths      = []string{programDir}
+		searchDirnames []string
+	)
+	if homeDir, err := userHomeDir(); err == nil {
+		if !stringSliceContains(basePaths, homeDir) {
+			basePaths = append(basePaths, homeDir)
 		}
-		for _, basePath := range basePaths {
-			for _, baseDirname := range baseDirnames {
-				searchDirname := filepath.Join(basePath, baseDirname)
-				if info, err := os.Stat(searchDirname); err == nil && info.IsDir() {
-					searchDirnames = append(searchDirnames, searchDirname)
-				}
+	}
+	if !stringSliceContains(basePaths, workingDir) {
+		basePaths = append(basePaths, workingDir)
+	}
+	for _, basePath := range basePaths {
+		for _, baseDirname := range formatDirBasenames {
+			searchDirname := filepath.Join(basePath, baseDirname)
+			if info, err := os.Stat(searchDirname); err == nil && info.IsDir() {
+				searchDirnames = append(searchDirnames, searchDirname)
 			}
 		}
-		return searchDirnames
-	})()
-
-	// Create a new instance of `config` and assign defaults.
-	c := &config{
-		common:  common{formatID: defaultFormatID, startName: defaultStartName},
-		outFile: defaultOutFile,
-		outMode: defaultOutMode,
-		trim:    defaultTrimState,
 	}
 
 	// Merge values from the environment variables.
 	if env := os.Getenv("TWEEGO_PATH"); env != "" {
-		formatDirs = append(formatDirs, filepath.SplitList(env)...)
+		searchDirnames = append(searchDirnames, filepath.SplitList(env)...)
+	}
+	return searchDirnames
+}
+
+// loadFormats enumerates the story formats within the search directories,
+// caching their metadata within the user's cache directory, if possible.  The
+// reporter is optional.
+func loadFormats(searchDirnames []string, report func(*twee.Diagnostic)) twee.Formats {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return twee.LoadFormats(searchDirnames, report)
+	}
+	return twee.LoadFormatsWithCache(searchDirnames, filepath.Join(cacheDir, "tweego", "formats.json"), report)
+}
+
+// newConfig creates a new config instance
+func newConfig() *config {
+	// Get the directories to search for story formats.
+	formatDirs := getFormatSearchDirs()
+
+	// Create a new instance of `config` and assign defaults.
+	c := &config{
+		diagFormat: defaultDiagFormat,
+		outFile:    defaultOutFile,
+		outMode:    defaultOutMode,
+		serveAddr:  defaultServeAddr,
+		trim:       defaultTrimState,
+		watchOpts: watchOptions{
+			debounce: defaultWatchDebounce,
+			ignores:  append([]string(nil), defaultWatchIgnores...),
+		},
 	}
 
 	// TODO: Move story formats out of the config?
@@ -112,8 +138,12 @@ func newConfig() *config {
 	if len(formatDirs) == 0 {
 		log.Fatal("error: Story format search directories not found.")
 	}
-	c.formats = newStoryFormatsMap(formatDirs)
-	if c.formats.isEmpty() {
+	// NOTE: The diagnostics format is not yet known, so the diagnostics are
+	// held until it is, see below.
+	c.formats = loadFormats(formatDirs, func(d *twee.Diagnostic) {
+		c.formatDiags = append(c.formatDiags, d)
+	})
+	if len(c.formats) == 0 {
 		log.Print("error: Story formats not found within the search directories: (in order)")
 		for i, path := range formatDirs {
 			log.Printf("  %2d. %s", i+1, path)
@@ -121,81 +151,203 @@ func newConfig() *config {
 		os.Exit(1)
 	}
 
-	// Merge values from the command line.
+	// Parse the command line.
 	options := option.NewParser()
+	options.Add("all_stories", "--all-stories")
 	options.Add("archive_twine2", "-a|--archive-twine2")
 	options.Add("archive_twine1", "--archive-twine1")
+	options.Add("archive_json", "--archive-json")
+	options.Add("config", "--config=s")
 	options.Add("decompile_twee3", "-d|--decompile-twee3|--decompile") // NOTE: "--decompile" is deprecated.
 	options.Add("decompile_twee1", "--decompile-twee1")
+	options.Add("decompile_split", "--decompile-split=s")
+	options.Add("diagnostics_format", "--diagnostics-format=s")
 	options.Add("encoding", "-c=s|--charset=s")
+	options.Add("exclude_source", "--exclude-source=s+")
+	options.Add("exclude_tag", "--exclude-tag=s+")
+	options.Add("exempt_tag", "--exempt-tag=s+")
 	options.Add("format", "-f=s|--format=s")
+	options.Add("graph_dot", "--graph-dot")
+	options.Add("graph_json", "--graph-json")
 	options.Add("head", "--head=s")
 	options.Add("help", "-h|--help")
 	options.Add("listcharsets", "--list-charsets")
 	options.Add("listformats", "--list-formats")
+	options.Add("liststories", "--list-stories")
 	options.Add("logfiles", "--log-files")
 	options.Add("logstats", "-l|--log-stats")
 	options.Add("module", "-m=s+|--module=s+")
 	options.Add("no_trim", "--no-trim")
 	options.Add("output", "-o=s|--output=s")
+	options.Add("profile", "--profile=s")
+	options.Add("proof", "-p|--proof")
+	options.Add("proofing_format", "--proofing-format=s")
+	options.Add("serve", "--serve")
+	options.Add("serve_addr", "--serve-addr=s")
 	options.Add("start", "-s=s|--start=s")
+	options.Add("story", "--story=s")
+	options.Add("strict_links", "--strict-links")
+	options.Add("strict_lock", "--strict-lock")
 	options.Add("test", "-t|--test")
 	options.Add("twee2_compat", "--twee2-compat")
+	options.Add("update_lock", "--update-lock")
 	options.Add("version", "-v|--version")
 	options.Add("watch", "-w|--watch")
-	if opts, sources, err := options.ParseCommandLine(); err == nil {
-		for opt, val := range opts {
-			switch opt {
-			case "archive_twine2":
-				c.outMode = outModeTwine2Archive
-			case "archive_twine1":
-				c.outMode = outModeTwine1Archive
-			case "decompile_twee3":
-				c.outMode = outModeTwee3
-			case "decompile_twee1":
-				c.outMode = outModeTwee1
-			case "encoding":
-				c.encoding = val.(string)
-			case "format":
-				c.cmdline.formatID = val.(string)
-				c.formatID = c.cmdline.formatID
-			case "head":
-				c.headFile = val.(string)
-			case "help":
+	options.Add("watch_debounce", "--watch-debounce=s")
+	options.Add("watch_ignore", "--watch-ignore=s+")
+	options.Add("watch_poll", "--watch-poll")
+	opts, sources, err := options.ParseCommandLine()
+	if err != nil {
+		log.Printf("error: %s", err.Error())
+		usage()
+	}
+
+	// Merge values from the project configuration file, if any.
+	configFilename := findConfigFile()
+	if val, ok := opts["config"]; ok {
+		configFilename = val.(string)
+	}
+	if val, ok := opts["profile"]; ok {
+		c.profile = val.(string)
+		if configFilename == "" {
+			log.Fatalf("error: Profile %q requires a project configuration file.", c.profile)
+		}
+	}
+	if configFilename != "" {
+		cf, err := loadConfigFile(configFilename)
+		if err != nil {
+			log.Fatalf("error: config %s: %s", configFilename, err.Error())
+		}
+		cf.apply(c, false)
+
+		// Merge values from the selected profile, if any.
+		if c.profile != "" {
+			profile, ok := cf.Profiles[c.profile]
+			if !ok {
+				log.Printf("error: config %s: Profile %q not found.", configFilename, c.profile)
+				if names := cf.profileNames(); len(names) > 0 {
+					log.Printf("Available profiles: %s", strings.Join(names, ", "))
+				}
+				os.Exit(1)
+			}
+			profile.apply(c, true)
+		}
+	}
+
+	// Merge values from the command line.
+	for opt, val := range opts {
+		switch opt {
+		case "all_stories":
+			c.allStories = true
+		case "archive_twine2":
+			c.outMode = twee.OutModeTwine2Archive
+		case "archive_twine1":
+			c.outMode = twee.OutModeTwine1Archive
+		case "archive_json":
+			c.outMode = twee.OutModeTwine2JSON
+		case "decompile_twee3":
+			c.outMode = twee.OutModeTwee3
+		case "decompile_twee1":
+			c.outMode = twee.OutModeTwee1
+		case "decompile_split":
+			if _, err := parseSplitMode(val.(string)); err != nil {
+				log.Printf("error: %s", err.Error())
+				usage()
+			}
+			c.outMode = twee.OutModeTwee3
+			c.splitBy = val.(string)
+		case "diagnostics_format":
+			diagFormat, err := parseDiagFormat(val.(string))
+			if err != nil {
+				log.Printf("error: %s", err.Error())
+				usage()
+			}
+			c.diagFormat = diagFormat
+		case "encoding":
+			c.encoding = val.(string)
+		case "exclude_source":
+			c.excludePaths = val.([]string)
+		case "exclude_tag":
+			c.excludeTags = val.([]string)
+		case "exempt_tag":
+			c.exemptTags = val.([]string)
+		case "format":
+			c.formatID = val.(string)
+		case "graph_dot":
+			c.outMode = twee.OutModeGraphDOT
+		case "graph_json":
+			c.outMode = twee.OutModeGraphJSON
+		case "head":
+			c.headFile = val.(string)
+		case "help":
+			usage()
+		case "listcharsets":
+			usageCharsets()
+		case "listformats":
+			usageFormats(c.formats)
+		case "liststories":
+			c.listStories = true
+		case "logfiles":
+			c.logFiles = true
+		case "logstats":
+			c.logStats = true
+		case "module":
+			c.modulePaths = val.([]string)
+		case "no_trim":
+			c.trim = false
+		case "output":
+			c.outFile = val.(string)
+		case "proof":
+			c.outMode = twee.OutModeProofing
+		case "proofing_format":
+			c.proofingFormatID = val.(string)
+		case "serve":
+			c.serveFiles = true
+		case "serve_addr":
+			c.serveAddr = val.(string)
+		case "start":
+			c.startName = val.(string)
+		case "story":
+			c.storyName = val.(string)
+		case "strict_links":
+			c.strictLinks = true
+		case "strict_lock":
+			c.strictLock = true
+		case "test":
+			c.testMode = true
+		case "twee2_compat":
+			c.twee2Compat = true
+		case "update_lock":
+			c.updateLock = true
+		case "version":
+			usageVersion()
+		case "watch":
+			c.watchFiles = true
+		case "watch_debounce":
+			debounce, err := parseWatchDebounce(val.(string))
+			if err != nil {
+				log.Printf("error: %s", err.Error())
 				usage()
-			case "listcharsets":
-				usageCharsets()
-			case "listformats":
-				usageFormats(c.formats)
-			case "logfiles":
-				c.logFiles = true
-			case "logstats":
-				c.logStats = true
-			case "module":
-				c.modulePaths = append(c.modulePaths, val.([]string)...)
-			case "no_trim":
-				c.trim = false
-			case "output":
-				c.outFile = val.(string)
-			case "start":
-				c.cmdline.startName = val.(string)
-				c.startName = c.cmdline.startName
-			case "test":
-				c.testMode = true
-			case "twee2_compat":
-				c.twee2Compat = true
-			case "version":
-				usageVersion()
-			case "watch":
-				c.watchFiles = true
 			}
+			c.watchOpts.debounce = debounce
+		case "watch_ignore":
+			c.watchOpts.ignores = append(c.watchOpts.ignores, val.([]string)...)
+		case "watch_poll":
+			c.watchOpts.poll = true
 		}
-		if len(sources) > 0 {
-			c.sourcePaths = append(c.sourcePaths, sources...)
+	}
+	if _, ok := opts["decompile_split"]; !ok {
+		// NOTE: An output mode given on the command line overrides splitting
+		// enabled by the project configuration file.
+		for _, opt := range []string{"archive_json", "archive_twine1", "archive_twine2", "decompile_twee1", "decompile_twee3", "graph_dot", "graph_json", "proof"} {
+			if _, ok := opts[opt]; ok {
+				c.splitBy = ""
+				break
+			}
 		}
-	} else {
-		log.Printf("error: %s", err.Error())
-		usage()
+	}
+	if len(sources) > 0 {
+		c.sourcePaths = sources
 	}
 
 	// Basic sanity checks.
@@ -209,6 +361,37 @@ func newConfig() *config {
 		log.Print("error: Input sources not specified.")
 		usage()
 	}
+	if c.listStories {
+		usageStories(c)
+	}
+	if c.allStories {
+		if c.outMode != twee.OutModeTwee3 && c.outMode != twee.OutModeTwee1 {
+			log.Fatal("error: Decompiling all stories is only supported when decompiling to Twee.")
+		}
+		if c.outFile == "-" {
+			log.Fatal("error: Decompiling all stories requires an output directory.")
+		}
+		if c.watchFiles || c.serveFiles {
+			log.Fatal("error: Decompiling all stories is unsupported in watch mode.")
+		}
+	}
+	if c.splitBy != "" {
+		if c.outMode != twee.OutModeTwee3 {
+			log.Fatal("error: Splitting is only supported when decompiling to Twee 3.")
+		}
+		if c.outFile == "-" {
+			log.Fatal("error: Splitting requires an output directory.")
+		}
+		if c.watchFiles || c.serveFiles {
+			log.Fatal("error: Splitting is unsupported in watch mode.")
+		}
+	}
+	if c.serveFiles {
+		if c.outMode != twee.OutModeHTML && c.outMode != twee.OutModeProofing {
+			log.Fatal("error: Serve mode is only supported when outputting compiled HTML or proofing copies.")
+		}
+		c.watchFiles = true
+	}
 	if c.watchFiles {
 		if c.outFile == "-" {
 			log.Fatal("error: Writing to standard output is unsupported in watch mode.")
@@ -220,37 +403,47 @@ func newConfig() *config {
 		// 	log.Print("warning: Statistic logging is unsupported in watch mode.")
 		// }
 	}
+	if c.diagFormat != diagFormatText && c.outFile == "-" && (c.logFiles || c.logStats) {
+		log.Fatal("error: Logging files or statistics requires an output file when the diagnostics format is not text.")
+	}
+
+	// Log the diagnostics of the story formats, unless they're to be included
+	// within the diagnostics of each build.
+	if c.diagFormat == diagFormatText {
+		for _, d := range c.formatDiags {
+			logDiagnostic(d)
+		}
+		c.formatDiags = nil
+	}
 
 	// Return the base configuration.
 	return c
 }
 
-func (c *config) mergeStoryConfig(s *story) {
-	if c.cmdline.formatID != "" {
-		c.formatID = c.cmdline.formatID
-	} else if s.twine2.format != "" {
-		c.formatID = c.formats.getIDFromTwine2NameAndVersion(s.twine2.format, s.twine2.formatVersion)
-		if c.formatID == "" {
-			log.Printf("error: Story format named %q at version %q is not available.", s.twine2.format, s.twine2.formatVersion)
-			usageFormats(c.formats)
-		}
-	} else {
-		c.formatID = defaultFormatID
-	}
-	if !c.formats.hasByID(c.formatID) {
-		log.Printf("error: Story format %q is not available.", c.formatID)
-		usageFormats(c.formats)
-	}
-
-	if c.cmdline.startName != "" {
-		c.startName = c.cmdline.startName
-	} else if s.twine2.start != "" {
-		c.startName = s.twine2.start
-	} else {
-		c.startName = defaultStartName
+// tweeOptions returns the options for loading and compiling the story.  The build
+// cache is optional.
+func (c *config) tweeOptions(cache *twee.Cache) *twee.Options {
+	return &twee.Options{
+		Encoding:         c.encoding,
+		NoTrim:           !c.trim,
+		Twee2Compat:      c.twee2Compat,
+		ExcludePaths:     c.excludePaths,
+		ExcludeTags:      c.excludeTags,
+		Story:            c.storyName,
+		OutFile:          c.outFile,
+		Cache:            cache,
+		OutMode:          c.outMode,
+		Formats:          c.formats,
+		FormatID:         c.formatID,
+		ProofingFormatID: c.proofingFormatID,
+		StartName:        c.startName,
+		TestMode:         c.testMode,
+		StrictLinks:      c.strictLinks,
+		ExemptTags:       c.exemptTags,
+		ModulePaths:      c.modulePaths,
+		HeadFile:         c.headFile,
+		Creator:          tweegoName,
+		CreatorVersion:   tweegoVersion.Version(),
+		SplitBy:          splitModes[c.splitBy],
 	}
-
-	// Finalize the story setup.
-	s.format = c.formats.getByID(c.formatID)
-	s.twine2.options["debug"] = s.twine2.options["debug"] || c.testMode
 }
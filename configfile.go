@@ -0,0 +1,284 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+	// external packages
+	"github.com/BurntSushi/toml"
+)
+
+// Base names of the project configuration files, in order of precedence,
+// searched for within the working directory.
+var configFileBasenames = []string{"tweego.json", "tweego.toml"}
+
+// Map of project configuration file output mode names to output modes.
+var configFileOutModes = map[string]twee.OutputMode{
+	"html":           twee.OutModeHTML,
+	"twee3":          twee.OutModeTwee3,
+	"twee1":          twee.OutModeTwee1,
+	"archive-twine2": twee.OutModeTwine2Archive,
+	"archive-twine1": twee.OutModeTwine1Archive,
+	"archive-json":   twee.OutModeTwine2JSON,
+	"graph-dot":      twee.OutModeGraphDOT,
+	"graph-json":     twee.OutModeGraphJSON,
+	"proof":          twee.OutModeProofing,
+}
+
+// configFile is the project configuration file.
+type configFile struct {
+	configFileSettings
+	Profiles map[string]*configFileSettings `json:"profiles"           toml:"profiles"`
+}
+
+// configFileSettings are the settings of either the project configuration file
+// itself or one of its named profiles.  Each field mirrors one of the command
+// line options.
+type configFileSettings struct {
+	Charset        string   `json:"charset"            toml:"charset"`
+	DecompileSplit string   `json:"decompile-split"    toml:"decompile-split"`
+	DiagFormat     string   `json:"diagnostics-format" toml:"diagnostics-format"`
+	ExcludeSources []string `json:"exclude-sources"    toml:"exclude-sources"`
+	ExcludeTags    []string `json:"exclude-tags"       toml:"exclude-tags"`
+	ExemptTags     []string `json:"exempt-tags"        toml:"exempt-tags"`
+	Format         string   `json:"format"             toml:"format"`
+	Head           string   `json:"head"               toml:"head"`
+	LogFiles       *bool    `json:"log-files"          toml:"log-files"`
+	LogStats       *bool    `json:"log-stats"          toml:"log-stats"`
+	Modules        []string `json:"modules"            toml:"modules"`
+	Output         string   `json:"output"             toml:"output"`
+	OutputMode     string   `json:"output-mode"        toml:"output-mode"`
+	ProofingFormat string   `json:"proofing-format"    toml:"proofing-format"`
+	Serve          *bool    `json:"serve"              toml:"serve"`
+	ServeAddr      string   `json:"serve-addr"         toml:"serve-addr"`
+	Sources        []string `json:"sources"            toml:"sources"`
+	Start          string   `json:"start"              toml:"start"`
+	Story          string   `json:"story"              toml:"story"`
+	StrictLinks    *bool    `json:"strict-links"       toml:"strict-links"`
+	StrictLock     *bool    `json:"strict-lock"        toml:"strict-lock"`
+	Test           *bool    `json:"test"               toml:"test"`
+	Trim           *bool    `json:"trim"               toml:"trim"`
+	Twee2Compat    *bool    `json:"twee2-compat"       toml:"twee2-compat"`
+	Watch          *bool    `json:"watch"              toml:"watch"`
+	WatchDebounce  string   `json:"watch-debounce"     toml:"watch-debounce"`
+	WatchIgnore    []string `json:"watch-ignore"       toml:"watch-ignore"`
+	WatchPoll      *bool    `json:"watch-poll"         toml:"watch-poll"`
+}
+
+// findConfigFile returns the name of the project configuration file within
+// the working directory, or an empty string if there is none.
+func findConfigFile() string {
+	for _, basename := range configFileBasenames {
+		filename := filepath.Join(workingDir, basename)
+		if info, err := os.Stat(filename); err == nil && info.Mode().IsRegular() {
+			return relPath(filename)
+		}
+	}
+	return ""
+}
+
+// loadConfigFile reads and decodes the given project configuration file.
+func loadConfigFile(filename string) (*configFile, error) {
+	source, err := twee.ReadFile(filename, "utf-8")
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &configFile{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(source))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cf); err != nil {
+			return nil, fmt.Errorf("Malformed JSON; %s.", err.Error())
+		}
+	case ".toml":
+		md, err := toml.Decode(string(source), cf)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed TOML; %s.", err.Error())
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("Unknown key %q.", undecoded[0].String())
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported file type; must be either .json or .toml.")
+	}
+
+	// Validate the settings and resolve relative paths against the directory
+	// of the file.
+	dir := filepath.Dir(filename)
+	if err := cf.configFileSettings.finalize(dir); err != nil {
+		return nil, err
+	}
+	for name, profile := range cf.Profiles {
+		if err := profile.finalize(dir); err != nil {
+			return nil, fmt.Errorf("profile %s: %s", name, err.Error())
+		}
+	}
+
+	return cf, nil
+}
+
+func (cs *configFileSettings) finalize(dir string) error {
+	if cs.OutputMode != "" {
+		if _, ok := configFileOutModes[cs.OutputMode]; !ok {
+			return fmt.Errorf("Unknown output mode %q.", cs.OutputMode)
+		}
+	}
+	if cs.DecompileSplit != "" {
+		if _, err := parseSplitMode(cs.DecompileSplit); err != nil {
+			return err
+		}
+	}
+	if cs.DiagFormat != "" {
+		if _, err := parseDiagFormat(cs.DiagFormat); err != nil {
+			return err
+		}
+	}
+	if cs.WatchDebounce != "" {
+		if _, err := parseWatchDebounce(cs.WatchDebounce); err != nil {
+			return err
+		}
+	}
+
+	cs.Head = configFileResolvePath(dir, cs.Head)
+	cs.Output = configFileResolvePath(dir, cs.Output)
+	for _, paths := range [][]string{cs.ExcludeSources, cs.Modules, cs.Sources} {
+		for i := range paths {
+			paths[i] = configFileResolvePath(dir, paths[i])
+		}
+	}
+
+	return nil
+}
+
+// profileNames returns the sorted names of the profiles.
+func (cf *configFile) profileNames() []string {
+	names := make([]string, 0, len(cf.Profiles))
+	for name := range cf.Profiles {
+		names = append(names, name)
+	}
+	sort.Sort(StringsInsensitively(names))
+	return names
+}
+
+func configFileResolvePath(dir, pathname string) string {
+	if pathname == "" || pathname == "-" || filepath.IsAbs(pathname) {
+		return pathname
+	}
+	return filepath.Join(dir, filepath.FromSlash(pathname))
+}
+
+// apply applies the settings to the given config instance.  Array settings
+// either replace or, if extend is enabled, are appended to the existing values.
+func (cs *configFileSettings) apply(c *config, extend bool) {
+	mergeList := func(existing, values []string) []string {
+		if extend {
+			return append(existing, values...)
+		}
+		return values
+	}
+
+	if cs.Charset != "" {
+		c.encoding = cs.Charset
+	}
+	if cs.DecompileSplit != "" {
+		c.outMode = twee.OutModeTwee3
+		c.splitBy = cs.DecompileSplit
+	}
+	if cs.DiagFormat != "" {
+		c.diagFormat = cs.DiagFormat
+	}
+	if len(cs.ExcludeSources) > 0 {
+		c.excludePaths = mergeList(c.excludePaths, cs.ExcludeSources)
+	}
+	if len(cs.ExcludeTags) > 0 {
+		c.excludeTags = mergeList(c.excludeTags, cs.ExcludeTags)
+	}
+	if len(cs.ExemptTags) > 0 {
+		c.exemptTags = mergeList(c.exemptTags, cs.ExemptTags)
+	}
+	if cs.Format != "" {
+		c.formatID = cs.Format
+	}
+	if cs.ProofingFormat != "" {
+		c.proofingFormatID = cs.ProofingFormat
+	}
+	if cs.Head != "" {
+		c.headFile = cs.Head
+	}
+	if cs.LogFiles != nil {
+		c.logFiles = *cs.LogFiles
+	}
+	if cs.LogStats != nil {
+		c.logStats = *cs.LogStats
+	}
+	if len(cs.Modules) > 0 {
+		c.modulePaths = mergeList(c.modulePaths, cs.Modules)
+	}
+	if cs.Output != "" {
+		c.outFile = cs.Output
+	}
+	if cs.OutputMode != "" {
+		c.outMode = configFileOutModes[cs.OutputMode]
+		if c.outMode != twee.OutModeTwee3 {
+			c.splitBy = ""
+		}
+	}
+	if cs.Serve != nil {
+		c.serveFiles = *cs.Serve
+	}
+	if cs.ServeAddr != "" {
+		c.serveAddr = cs.ServeAddr
+	}
+	if len(cs.Sources) > 0 {
+		c.sourcePaths = mergeList(c.sourcePaths, cs.Sources)
+	}
+	if cs.Start != "" {
+		c.startName = cs.Start
+	}
+	if cs.Story != "" {
+		c.storyName = cs.Story
+	}
+	if cs.StrictLinks != nil {
+		c.strictLinks = *cs.StrictLinks
+	}
+	if cs.StrictLock != nil {
+		c.strictLock = *cs.StrictLock
+	}
+	if cs.Test != nil {
+		c.testMode = *cs.Test
+	}
+	if cs.Trim != nil {
+		c.trim = *cs.Trim
+	}
+	if cs.Twee2Compat != nil {
+		c.twee2Compat = *cs.Twee2Compat
+	}
+	if cs.Watch != nil {
+		c.watchFiles = *cs.Watch
+	}
+	if cs.WatchDebounce != "" {
+		c.watchOpts.debounce, _ = parseWatchDebounce(cs.WatchDebounce) // Validated by `finalize()`.
+	}
+	if len(cs.WatchIgnore) > 0 {
+		// NOTE: Ignore patterns are always in addition to the defaults.
+		c.watchOpts.ignores = append(c.watchOpts.ignores, cs.WatchIgnore...)
+	}
+	if cs.WatchPoll != nil {
+		c.watchOpts.poll = *cs.WatchPoll
+	}
+}
@@ -0,0 +1,69 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// Map of decompile split mode names to split modes.
+var splitModes = map[string]twee.SplitMode{
+	"passage": twee.SplitByPassage,
+	"tag":     twee.SplitByTag,
+}
+
+// parseSplitMode parses the decompile split mode.
+func parseSplitMode(value string) (twee.SplitMode, error) {
+	if mode, ok := splitModes[value]; ok {
+		return mode, nil
+	}
+	return 0, fmt.Errorf("Decompile split mode %q is invalid; must be one of: %q, %q.", value, "passage", "tag")
+}
+
+// checkTreeConflicts returns an error if any of the files of the project tree
+// exist within the directory with differing contents, so that writing the tree
+// would overwrite them.
+func checkTreeConflicts(dirname string, files []*twee.TreeFile) error {
+	var conflicts []string
+	for _, f := range files {
+		filename := filepath.Join(dirname, filepath.FromSlash(f.Name))
+		if data, err := ioutil.ReadFile(filename); err == nil && !bytes.Equal(data, f.Data) {
+			conflicts = append(conflicts, filename)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Refusing to overwrite differing files: %s; remove them or write the output elsewhere.", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// writeTree writes the files of the decompiled project tree into the directory,
+// creating it and any subdirectories as necessary.  Files whose contents are
+// unchanged are not rewritten, so as not to trigger rebuilds in watch mode.
+func writeTree(dirname string, files []*twee.TreeFile) error {
+	for _, f := range files {
+		filename := filepath.Join(dirname, filepath.FromSlash(f.Name))
+		if data, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(data, f.Data) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, f.Data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// Diagnostics output formats.
+const (
+	diagFormatText  = "text"  // Log lines—e.g., `warning: chapter3.tw:212: …`.
+	diagFormatJSON  = "json"  // JSON array of diagnostics.
+	diagFormatSARIF = "sarif" // SARIF v2.1.0 log.
+)
+
+const defaultDiagFormat = diagFormatText
+
+// parseDiagFormat parses the diagnostics output format.
+func parseDiagFormat(value string) (string, error) {
+	switch value {
+	case diagFormatText, diagFormatJSON, diagFormatSARIF:
+		return value, nil
+	}
+	return "", fmt.Errorf("Diagnostics format %q is invalid; must be one of: %q, %q, %q.", value, diagFormatText, diagFormatJSON, diagFormatSARIF)
+}
+
+// logDiagnostic logs the diagnostic as a text line—e.g.,
+// `warning: chapter3.tw:212: …`.
+func logDiagnostic(d *twee.Diagnostic) {
+	log.Printf("%s: %s", d.Severity, d.Error())
+}
+
+// writeDiagnostics writes the diagnostics to w in the given machine-readable
+// format.
+func writeDiagnostics(w io.Writer, format string, diags []*twee.Diagnostic) error {
+	var doc interface{}
+	switch format {
+	case diagFormatSARIF:
+		doc = newSARIFLog(diags)
+	default:
+		if diags == nil {
+			diags = []*twee.Diagnostic{}
+		}
+		doc = diags
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(doc)
+}
+
+/*
+	SARIF (Static Analysis Results Interchange Format) v2.1.0.
+
+	Only the subset of the format necessary to describe the diagnostics is
+	modeled, see: https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+*/
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// ID of the URI base of artifacts within the working directory.
+const sarifSrcRoot = "%SRCROOT%"
+
+// newSARIFArtifactLocation returns the location of the file—i.e., its path
+// relative to the working directory, against sarifSrcRoot, if within it,
+// elsewise its absolute file URI.
+func newSARIFArtifactLocation(filename string) sarifArtifactLocation {
+	absolute := filename
+	if !filepath.IsAbs(absolute) {
+		absolute = filepath.Join(workingDir, absolute)
+	}
+	if rel, err := filepath.Rel(workingDir, absolute); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return sarifArtifactLocation{URI: (&url.URL{Path: filepath.ToSlash(rel)}).String(), URIBaseID: sarifSrcRoot}
+	}
+	return sarifArtifactLocation{URI: fileURI(absolute)}
+}
+
+// fileURI returns the file URI of the absolute path.
+func fileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // E.g., `C:/foo` → `/C:/foo`.
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+}
+
+// newSARIFLog returns a SARIF log containing the diagnostics.
+func newSARIFLog(diags []*twee.Diagnostic) *sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           tweegoName,
+			Version:        tweegoVersion.Version(),
+			InformationURI: "http://www.motoslave.net/tweego/",
+		}},
+		Results: make([]sarifResult, 0, len(diags)),
+	}
+	if workingDir != "" {
+		// NOTE: The URI of a base must end with a slash.
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			sarifSrcRoot: {URI: strings.TrimSuffix(fileURI(workingDir), "/") + "/"},
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range diags {
+		if d.Code != "" && !seen[d.Code] {
+			seen[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+
+		result := sarifResult{
+			RuleID:  d.Code,
+			Level:   d.Severity.String(),
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.Filename != "" || d.Passage != "" {
+			var loc sarifLocation
+			if d.Filename != "" {
+				loc.PhysicalLocation = &sarifPhysicalLocation{
+					ArtifactLocation: newSARIFArtifactLocation(d.Filename),
+				}
+				if d.Line > 0 {
+					loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+				}
+			}
+			if d.Passage != "" {
+				loc.LogicalLocations = []sarifLogicalLocation{{Name: d.Passage}}
+			}
+			result.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
@@ -0,0 +1,109 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"bytes"
+	"fmt"
+	"html"
+	"time"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+const (
+	errorPageContext   = 2   // Number of lines of context to show around the offending line.
+	errorPageLineLimit = 160 // Maximum number of characters to show per line.
+)
+
+const errorPageStyle = `body{margin:2em;background:#1e1e1e;color:#ddd;font-family:sans-serif;}
+h1{color:#f66;}
+.error{margin:1.5em 0;padding:1em;border-left:4px solid #f66;background:#2a2a2a;}
+.message{font-weight:bold;white-space:pre-wrap;}
+.position{margin-top:0.5em;color:#aaa;font-family:monospace;}
+pre{margin:0.5em 0 0;padding:0.5em;overflow-x:auto;background:#111;}
+.offending{display:inline-block;min-width:100%;background:#5a1d1d;}`
+
+// newErrorPage returns an HTML page listing the errors of a failed build,
+// along with a snippet of the offending source for each, if possible.
+func newErrorPage(err error, encoding string) []byte {
+	var (
+		b       bytes.Buffer
+		sources = make(map[string][][]byte)
+		errs    = twee.FlattenErrors(err)
+	)
+
+	fmt.Fprint(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>Build failed: %s</title>\n", html.EscapeString(tweegoName))
+	fmt.Fprintf(&b, "<style>%s</style>\n</head>\n<body>\n", errorPageStyle)
+	fmt.Fprint(&b, "<h1>Build failed</h1>\n")
+	fmt.Fprintf(&b, "<p>%s found %d error(s) at %s.  The story will return once they're fixed.</p>\n",
+		html.EscapeString(tweegoName),
+		len(errs),
+		html.EscapeString(time.Now().Format(time.RFC1123Z)),
+	)
+
+	for _, err := range errs {
+		fmt.Fprint(&b, "<div class=\"error\">\n")
+		diag, ok := err.(*twee.Diagnostic)
+		if !ok {
+			fmt.Fprintf(&b, "<div class=\"message\">%s</div>\n", html.EscapeString(err.Error()))
+			fmt.Fprint(&b, "</div>\n")
+			continue
+		}
+
+		fmt.Fprintf(&b, "<div class=\"message\">%s</div>\n", html.EscapeString(diag.Message))
+		if diag.Filename == "" {
+			fmt.Fprint(&b, "</div>\n")
+			continue
+		}
+		if diag.Line > 0 {
+			fmt.Fprintf(&b, "<div class=\"position\">%s, line %d</div>\n", html.EscapeString(diag.Filename), diag.Line)
+		} else {
+			fmt.Fprintf(&b, "<div class=\"position\">%s</div>\n", html.EscapeString(diag.Filename))
+		}
+
+		// Add the source snippet.
+		if diag.Line > 0 {
+			lines, ok := sources[diag.Filename]
+			if !ok {
+				if source, err := twee.ReadFile(diag.Filename, encoding); err == nil {
+					lines = bytes.Split(bytes.TrimRight(source, "\r\n"), []byte{'\n'})
+				}
+				sources[diag.Filename] = lines
+			}
+			if diag.Line <= len(lines) {
+				fmt.Fprint(&b, "<pre>")
+				first := diag.Line - errorPageContext
+				if first < 1 {
+					first = 1
+				}
+				last := diag.Line + errorPageContext
+				if last > len(lines) {
+					last = len(lines)
+				}
+				for i := first; i <= last; i++ {
+					line := []rune(string(lines[i-1]))
+					if len(line) > errorPageLineLimit {
+						line = append(line[:errorPageLineLimit], '…')
+					}
+					text := fmt.Sprintf("%5d | %s", i, html.EscapeString(string(line)))
+					if i == diag.Line {
+						text = `<span class="offending">` + text + `</span>`
+					}
+					fmt.Fprintln(&b, text)
+				}
+				fmt.Fprint(&b, "</pre>\n")
+			}
+		}
+		fmt.Fprint(&b, "</div>\n")
+	}
+
+	fmt.Fprint(&b, "</body>\n</html>\n")
+	return b.Bytes()
+}
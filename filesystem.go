@@ -8,13 +8,16 @@ package main
 
 import (
 	// standard packages
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
-	// external packages
-	"github.com/radovskyb/watcher"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
 )
 
 var programDir string
@@ -32,137 +35,144 @@ func init() {
 	}
 }
 
-var errNoOutToIn = fmt.Errorf("no output to input source")
+// Default patterns of paths ignored by watch mode.
+var defaultWatchIgnores = []string{
+	// Version control.
+	".git", ".hg", ".svn",
 
-// Walk the specified pathnames, collecting regular files.
-func getFilenames(pathnames []string, outFilename string) []string {
-	var (
-		filenames  []string
-		absOutFile string
-	)
-	var fileWalker filepath.WalkFunc = func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	// Package managers.
+	"node_modules",
 
-		if !info.Mode().IsRegular() {
-			return nil
-		}
+	// Editor swap, backup, and lock files.
+	"*~", "*.swp", "*.swo", "*.swx", "4913", ".#*", "#*#",
 
-		absolute, err := filepath.Abs(path)
-		if err != nil {
-			return err
-		}
-		if absolute == absOutFile {
-			return errNoOutToIn
-		}
-		relative, _ := filepath.Rel(workingDir, absolute) // Failure is okay.
-		if relative != "" {
-			filenames = append(filenames, relative)
-		} else {
-			filenames = append(filenames, absolute)
-		}
-		return nil
+	// Operating systems.
+	".DS_Store", "Thumbs.db",
+}
+
+const defaultWatchDebounce = time.Millisecond * 500
+
+// watchOptions are the options of watch mode.
+type watchOptions struct {
+	debounce time.Duration // Quiet period after a change before building.
+	ignores  []string      // Patterns of paths to ignore, see `ignored()`.
+	poll     bool          // Whether to use the polling backend, even if a native one is available.
+}
+
+// parseWatchDebounce parses the watch mode debounce period—e.g., `250ms`.
+func parseWatchDebounce(value string) (time.Duration, error) {
+	debounce, err := time.ParseDuration(value)
+	if err != nil || debounce < 0 {
+		return 0, fmt.Errorf("Watch debounce %q is invalid; must be a non-negative duration (e.g., \"250ms\").", value)
 	}
+	return debounce, nil
+}
 
-	// Get the absolute output filename.
-	absOutFile, err := filepath.Abs(outFilename)
-	if err != nil {
-		log.Fatalf("error: path %s: %s", outFilename, err.Error())
+// ignored reports whether the pathname matches any of the ignore patterns.
+// Patterns containing a slash are matched against the slash-separated path
+// relative to the working directory, all others against each of the path's
+// elements—e.g., `node_modules` matches `lib/node_modules/foo.js`.
+func (o *watchOptions) ignored(pathname string) bool {
+	if len(o.ignores) == 0 {
+		return false
 	}
 
-	for _, pathname := range pathnames {
-		if pathname == "-" {
-			log.Print("warning: path -: Reading from standard input is unsupported.")
+	relative := filepath.ToSlash(relPath(pathname))
+	elems := strings.Split(relative, "/")
+	for _, pattern := range o.ignores {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, relative); ok {
+				return true
+			}
 			continue
-		} else if err := filepath.Walk(pathname, fileWalker); err != nil {
-			if err == errNoOutToIn {
-				log.Fatalf("error: path %s: Output file cannot be an input source.", pathname)
-			} else {
-				log.Printf("warning: path %s: %s", pathname, err.Error())
-				continue
+		}
+		for _, elem := range elems {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
 			}
 		}
 	}
-
-	return filenames
+	return false
 }
 
-// Watch the specified pathnames, calling the build callback as necessary.
-func watchFilesystem(pathnames []string, outFilename string, buildCallback func()) {
-	var (
-		buildRate = time.Millisecond * 500
-		pollRate  = buildRate * 2
-	)
-
-	// Create a new watcher instance.
-	w := watcher.New()
-
-	// Only notify on certain events.
-	w.FilterOps(
-		watcher.Create,
-		watcher.Write,
-		watcher.Remove,
-		watcher.Rename,
-		watcher.Move,
-	)
+// watchOp is the kind of a filesystem change.
+type watchOp int
 
-	// Ignore the output file.
-	w.Ignore(outFilename)
+const (
+	watchCreate watchOp = iota
+	watchWrite
+	watchRemove
+	watchRename
+	watchOverflow // Changes were missed—e.g., the native backend's event queue overflowed.
+)
 
-	// Start a goroutine to handle the event loop.
-	go func() {
-		build := false
+func (op watchOp) String() string {
+	switch op {
+	case watchCreate:
+		return "CREATE"
+	case watchWrite:
+		return "WRITE"
+	case watchRemove:
+		return "REMOVE"
+	case watchRename:
+		return "RENAME"
+	case watchOverflow:
+		return "OVERFLOW"
+	}
+	return "UNKNOWN"
+}
 
-		for {
-			select {
-			case <-time.After(buildRate):
-				if build {
-					buildCallback()
-					build = false
-				}
-			case event := <-w.Event:
-				if event.FileInfo != nil {
-					isDir := event.IsDir()
+// watchEvent is a filesystem change reported by a watcher backend.
+type watchEvent struct {
+	op      watchOp
+	path    string
+	oldPath string // Previous path of a renamed file.
+	isDir   bool
+}
 
-					if event.Op == watcher.Write && isDir {
-						continue
-					}
+// Watcher backends—`watchNative()` and `watchPolling()`—recursively watch the
+// specified pathnames, skipping those which are ignored, and report changes on
+// the returned event channel.
+var errWatchNativeUnsupported = errors.New("native watching unsupported")
 
-					var pathname string
-					switch event.Op {
-					case watcher.Move, watcher.Rename:
-						pathname = fmt.Sprintf("%s -> %s", relPath(event.OldPath), relPath(event.Path))
-						if !build && !isDir {
-							build = knownFileType(event.OldPath) || knownFileType(event.Path)
-						}
-					default:
-						pathname = relPath(event.Path)
-						if !build && !isDir {
-							build = knownFileType(event.Path)
-						}
-					}
-					log.Printf("%s: %s", event.Op, pathname)
-				}
-			case err := <-w.Error:
-				log.Fatalln(err)
-			case <-w.Closed:
-				return
-			}
+// Watch the specified pathnames, calling the build callback as necessary.  The
+// callback's rescan parameter reports whether files may have been created,
+// removed, or renamed since the previous call, requiring the pathnames to be
+// walked again.
+func watchFilesystem(pathnames []string, outFilename string, opts watchOptions, buildCallback func(rescan bool)) {
+	absOutFile, _ := filepath.Abs(outFilename) // Failure is okay.
+	ignored := func(pathname string) bool {
+		if absolute, err := filepath.Abs(pathname); err == nil && absolute == absOutFile {
+			return true
 		}
-	}()
+		return opts.ignored(pathname)
+	}
 
-	// Recursively watch the specified paths for changes.
-	for _, pathname := range pathnames {
-		if err := w.AddRecursive(pathname); err != nil {
-			log.Fatalln(err)
+	// Start the native backend, if available and not disabled, elsewise fall
+	// back to the polling backend.
+	var (
+		backend = "native"
+		events  <-chan watchEvent
+		errs    <-chan error
+		err     error
+	)
+	if !opts.poll {
+		events, errs, err = watchNative(pathnames, ignored)
+		if err != nil && err != errWatchNativeUnsupported {
+			log.Printf("warning: watch: Falling back to polling; %s", err.Error())
+		}
+	}
+	if opts.poll || err != nil {
+		backend = "polling"
+		if events, errs, err = watchPolling(pathnames, ignored); err != nil {
+			log.Fatalf("error: watch: %s", err.Error())
 		}
 	}
 
 	// Print a message telling the user how to cancel watching
 	// and list all paths being watched.
 	log.Print()
-	log.Print("Watch mode started.  Press CTRL+C to stop.")
+	log.Printf("Watch mode started (%s).  Press CTRL+C to stop.", backend)
 	log.Print()
 	log.Printf("Recursively watched paths: %d", len(pathnames))
 	for _, pathname := range pathnames {
@@ -170,12 +180,66 @@ func watchFilesystem(pathnames []string, outFilename string, buildCallback func(
 	}
 	log.Print()
 
-	// Build the ouput once before the watcher starts.
-	buildCallback()
+	// Build the ouput once before handling events.
+	buildCallback(true)
+
+	// Handle events, building once they've been quiet for the debounce period.
+	var (
+		build  = false
+		rescan = false
+		timer  = time.NewTimer(opts.debounce)
+	)
+	timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if build {
+				buildCallback(rescan)
+				build = false
+				rescan = false
+			}
+		case event := <-events:
+			if event.op == watchWrite && event.isDir {
+				continue
+			}
+			if event.op != watchWrite {
+				rescan = true
+			}
 
-	// Start watching.
-	if err := w.Start(pollRate); err != nil {
-		log.Fatalln(err)
+			// NOTE: Directories are created, removed, and renamed along with
+			// their contents, so changes to them also require a build.
+
+			var pathname string
+			switch event.op {
+			case watchOverflow:
+				pathname = "Changes may have been missed; rescanning"
+				build = true
+			case watchRename:
+				pathname = fmt.Sprintf("%s -> %s", relPath(event.oldPath), relPath(event.path))
+				if event.isDir || twee.KnownFileType(event.oldPath) || twee.KnownFileType(event.path) {
+					build = true
+				}
+			default:
+				pathname = relPath(event.path)
+				if event.isDir || twee.KnownFileType(event.path) {
+					build = true
+				}
+			}
+			log.Printf("%s: %s", event.op, pathname)
+
+			// Restart the quiet period.
+			if build {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(opts.debounce)
+			}
+		case err := <-errs:
+			log.Printf("error: watch: %s", err.Error())
+		}
 	}
 }
 
@@ -194,22 +258,3 @@ func relPath(original string) string {
 
 	return relative
 }
-
-func knownFileType(filename string) bool {
-	switch normalizedFileExt(filename) {
-	// NOTE: The case values here should match those in `storyload.go:(*story).load()`.
-	case "tw", "twee",
-		"tw2", "twee2",
-		"htm", "html",
-		"css",
-		"js",
-		"otf", "ttf", "woff", "woff2",
-		"gif", "jpeg", "jpg", "png", "svg", "tif", "tiff", "webp",
-		"aac", "flac", "m4a", "mp3", "oga", "ogg", "opus", "wav", "wave", "weba",
-		"mp4", "ogv", "webm",
-		"vtt":
-		return true
-	}
-
-	return false
-}
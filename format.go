@@ -0,0 +1,161 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/internal/option"
+	"github.com/tmedwards/tweego/pkg/twee"
+	// external packages
+	"github.com/paulrosania/go-charset/charset"
+)
+
+// formatMain runs the `fmt` command, which rewrites Twee source files in
+// canonical form, then exits.
+func formatMain(args []string) {
+	options := option.NewParser()
+	options.Add("check", "--check")
+	options.Add("encoding", "-c=s|--charset=s")
+	options.Add("help", "-h|--help")
+	opts, sources, err := options.Parse(args)
+	if err != nil {
+		log.Printf("error: %s", err.Error())
+		usage()
+	}
+
+	var (
+		check    bool
+		encoding string
+	)
+	for opt, val := range opts {
+		switch opt {
+		case "check":
+			check = true
+		case "encoding":
+			encoding = val.(string)
+		case "help":
+			usage()
+		}
+	}
+	if encoding != "" {
+		if cs := charset.Info(encoding); cs == nil {
+			log.Printf("error: Charset %q is unsupported.", encoding)
+			usageCharsets()
+		}
+	}
+	if len(sources) == 0 {
+		log.Print("error: Input sources not specified.")
+		usage()
+	}
+
+	filenames, err := getFormatFilenames(sources)
+	if err != nil {
+		log.Fatalf("error: %s", err.Error())
+	}
+
+	failed := false
+	for _, filename := range filenames {
+		changed, err := formatFile(filename, encoding, !check)
+		if err != nil {
+			log.Printf("error: %s", err.Error())
+			failed = true
+			continue
+		}
+		if changed && check {
+			// Like `gofmt -l`, list the files whose formatting differs.
+			fmt.Println(filename)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// getFormatFilenames returns the names of the Twee 3 source files within the
+// pathnames.  Directories are searched recursively, skipping those which are
+// hidden or contain packages—e.g., `.git` and `node_modules`.
+func getFormatFilenames(pathnames []string) ([]string, error) {
+	var filenames []string
+	for _, pathname := range pathnames {
+		info, err := os.Stat(pathname)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			// NOTE: Twee2 sources are not supported, as the formatter emits
+			// Twee 3 headers.
+			if ext := strings.ToLower(filepath.Ext(pathname)); ext == ".tw2" || ext == ".twee2" {
+				return nil, fmt.Errorf("fmt %s: Twee2 sources are unsupported.", pathname)
+			}
+			filenames = append(filenames, pathname)
+			continue
+		}
+		err = filepath.Walk(pathname, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != pathname && (strings.HasPrefix(info.Name(), ".") || info.Name() == "node_modules") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".tw", ".twee":
+				if info.Mode().IsRegular() {
+					filenames = append(filenames, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filenames, nil
+}
+
+// formatFile formats the Twee source file, reporting whether its formatting
+// differs from the canonical form.  If write is true, the file is rewritten
+// in canonical form, as UTF-8, when it differs.
+func formatFile(filename, encoding string, write bool) (bool, error) {
+	original, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	source, err := twee.ReadFile(filename, encoding)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := twee.FormatTwee(filename, source, nil)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(original, formatted) {
+		return false, nil
+	}
+	if write {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return true, err
+		}
+		if err := ioutil.WriteFile(filename, formatted, info.Mode().Perm()); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -3,6 +3,7 @@ module github.com/tmedwards/tweego
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/Masterminds/semver/v3 v3.0.3
 	github.com/paulrosania/go-charset v0.0.0-20190326053356-55c9d7a5834c
 	github.com/radovskyb/watcher v1.0.7
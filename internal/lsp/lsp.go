@@ -0,0 +1,698 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+/*
+Package lsp implements a Language Server Protocol server for Twee sources.
+
+The server speaks LSP over a pair of streams—normally, standard input and
+output—and provides passage name completion within links, go to definition
+for link targets, document symbols for passages, and diagnostics for malformed
+Twee source and broken links.
+
+The passages of the workspace are indexed from its Twee sources—i.e., files
+with the .tw, .twee, .tw2, or .twee2 extensions—with open documents taking
+precedence over their files.  Documents only see the passages of their own
+project, see (*server).projects.
+*/
+package lsp
+
+import (
+	// standard packages
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// Base names of the project configuration files, whose directories are the
+// roots of projects.
+var configFileBasenames = []string{"tweego.json", "tweego.toml"}
+
+// Names of directories skipped when indexing the workspace.
+var skipDirnames = map[string]bool{
+	".git":         true,
+	".hg":          true,
+	".svn":         true,
+	"node_modules": true,
+}
+
+// document is a Twee source of the workspace.
+type document struct {
+	uri      string
+	lines    []string
+	passages []*twee.Passage // Passages of the last successful parse.
+	err      *twee.Diagnostic
+	open     bool // Whether the client has the document open.
+}
+
+// server is the state of the language server.
+type server struct {
+	w          io.Writer
+	docs       map[string]*document // Documents, keyed by URI.
+	published  map[string]bool      // URIs whose last published diagnostics were not empty.
+	roots      []string             // Paths of the workspace folders.
+	configDirs map[string]bool      // Paths of the directories containing project configuration files.
+	shutdown   bool
+}
+
+// Serve serves the Language Server Protocol, reading requests from r and
+// writing responses to w, until the client exits.
+func Serve(r io.Reader, w io.Writer) error {
+	srv := &server{
+		w:          w,
+		docs:       make(map[string]*document),
+		published:  make(map[string]bool),
+		configDirs: make(map[string]bool),
+	}
+	br := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(br)
+		if err != nil {
+			if rerr, ok := err.(*responseError); ok {
+				if err := srv.reply(nil, nil, rerr); err != nil {
+					return err
+				}
+				continue
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := srv.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles the message.  Only errors writing to the client are returned.
+func (srv *server) handle(msg *message) error {
+	var (
+		result interface{}
+		rerr   *responseError
+	)
+	switch msg.Method {
+	case "initialize":
+		var params initializeParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			srv.indexWorkspace(params)
+			result = map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"textDocumentSync": 1, // Full.
+					"completionProvider": map[string]interface{}{
+						"triggerCharacters": []string{"[", "|", ">"},
+					},
+					"definitionProvider":     true,
+					"documentSymbolProvider": true,
+				},
+				"serverInfo": map[string]string{"name": "tweego"},
+			}
+		}
+	case "initialized":
+		return srv.publishDiagnostics()
+	case "shutdown":
+		srv.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if unmarshalParams(msg, &params) == nil {
+			srv.update(normalizeURI(params.TextDocument.URI), params.TextDocument.Text, true)
+			return srv.publishDiagnostics()
+		}
+		return nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if unmarshalParams(msg, &params) == nil && len(params.ContentChanges) > 0 {
+			// NOTE: Only full document synchronization is supported, so the
+			// last change contains the entire text.
+			srv.update(normalizeURI(params.TextDocument.URI), params.ContentChanges[len(params.ContentChanges)-1].Text, true)
+			return srv.publishDiagnostics()
+		}
+		return nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if unmarshalParams(msg, &params) == nil {
+			srv.close(normalizeURI(params.TextDocument.URI))
+			return srv.publishDiagnostics()
+		}
+		return nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if rerr = unmarshalPositionParams(msg, &params); rerr == nil {
+			result = srv.completion(params)
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if rerr = unmarshalPositionParams(msg, &params); rerr == nil {
+			result = srv.definition(params)
+		}
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = srv.documentSymbols(params)
+		}
+	default:
+		if msg.ID == nil {
+			// Ignore unsupported notifications.
+			return nil
+		}
+		rerr = &responseError{codeMethodNotFound, "Unsupported method " + msg.Method + "."}
+	}
+
+	if msg.ID == nil {
+		return nil
+	}
+	return srv.reply(msg.ID, result, rerr)
+}
+
+func unmarshalParams(msg *message, v interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// unmarshalPositionParams unmarshals the parameters of a request at a position,
+// which must not be negative.
+func unmarshalPositionParams(msg *message, params *textDocumentPositionParams) *responseError {
+	if rerr := unmarshalParams(msg, params); rerr != nil {
+		return rerr
+	}
+	if params.Position.Line < 0 || params.Position.Character < 0 {
+		return &responseError{codeInvalidParams, "Position must not be negative."}
+	}
+	return nil
+}
+
+func (srv *server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	if rerr != nil {
+		return writeMessage(srv.w, &message{ID: id, Error: rerr})
+	}
+	if result == nil {
+		// NOTE: Successful responses must contain a result, even if null.
+		result = json.RawMessage("null")
+	}
+	return writeMessage(srv.w, &message{ID: id, Result: result})
+}
+
+func (srv *server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(srv.w, &message{Method: method, Params: data})
+}
+
+/*
+	Documents.
+*/
+
+// isTweeFile reports whether the file is a Twee source.
+func isTweeFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tw", ".twee", ".tw2", ".twee2":
+		return true
+	}
+	return false
+}
+
+// isConfigFile reports whether the file is a project configuration file.
+func isConfigFile(filename string) bool {
+	base := filepath.Base(filename)
+	for _, basename := range configFileBasenames {
+		if base == basename {
+			return true
+		}
+	}
+	return false
+}
+
+// isTwee2File reports whether the file is a Twee2 source.
+func isTwee2File(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tw2", ".twee2":
+		return true
+	}
+	return false
+}
+
+// indexWorkspace loads the Twee sources within the workspace folders.
+func (srv *server) indexWorkspace(params initializeParams) {
+	var roots []string
+	for _, folder := range params.WorkspaceFolders {
+		roots = append(roots, uriToPath(folder.URI))
+	}
+	if len(roots) == 0 {
+		switch {
+		case params.RootURI != "":
+			roots = append(roots, uriToPath(params.RootURI))
+		case params.RootPath != "":
+			roots = append(roots, params.RootPath)
+		}
+	}
+
+	for _, root := range roots {
+		if absolute, err := filepath.Abs(root); err == nil {
+			root = absolute
+		}
+		srv.roots = append(srv.roots, root)
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // Failure is okay.
+			}
+			if info.IsDir() {
+				if path != root && skipDirnames[info.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && isConfigFile(path) {
+				srv.configDirs[filepath.Dir(path)] = true
+			}
+			if info.Mode().IsRegular() && isTweeFile(path) {
+				if source, err := ioutil.ReadFile(path); err == nil {
+					srv.update(pathToURI(path), string(source), false)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// update parses the text of the document, replacing any previous version.
+func (srv *server) update(uri, text string, open bool) {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	doc, ok := srv.docs[uri]
+	if !ok {
+		doc = &document{uri: uri}
+		srv.docs[uri] = doc
+	}
+	doc.open = doc.open || open
+	doc.lines = splitLines(text)
+
+	filename := uriToPath(uri)
+	passages, err := twee.ParseTwee(filename, []byte(strings.Join(doc.lines, "\n")), &twee.Options{
+		Twee2Compat: isTwee2File(filename),
+		Report:      func(*twee.Diagnostic) {}, // Ignore warnings.
+	})
+	doc.err = nil
+	if err != nil {
+		// Keep the passages of the last successful parse, so that a malformed
+		// header, while it's being typed, doesn't break the entire index.
+		if d, ok := err.(*twee.Diagnostic); ok {
+			doc.err = d
+		} else {
+			doc.err = &twee.Diagnostic{Severity: twee.SeverityError, Message: err.Error()}
+		}
+		return
+	}
+	doc.passages = passages
+}
+
+// close reverts the document to its file or, if it has none, removes it.
+func (srv *server) close(uri string) {
+	doc, ok := srv.docs[uri]
+	if !ok {
+		return
+	}
+	doc.open = false
+	if source, err := ioutil.ReadFile(uriToPath(uri)); err == nil {
+		srv.update(uri, string(source), false)
+	} else {
+		delete(srv.docs, uri)
+	}
+}
+
+// passageNames returns the set of the names of the passages of the documents.
+func (srv *server) passageNames(uris []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, uri := range uris {
+		for _, p := range srv.docs[uri].passages {
+			names[p.Name()] = true
+		}
+	}
+	return names
+}
+
+// sortedURIs returns the URIs of the documents, sorted.
+func (srv *server) sortedURIs() []string {
+	uris := make([]string, 0, len(srv.docs))
+	for uri := range srv.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// projectURIs returns the sorted URIs of the documents within the project of
+// the document.
+func (srv *server) projectURIs(uri string) []string {
+	projects := srv.projects()
+	var uris []string
+	for _, other := range srv.sortedURIs() {
+		if projects[other] == projects[uri] {
+			uris = append(uris, other)
+		}
+	}
+	return uris
+}
+
+// projects returns the root directory of the project of each document, keyed
+// by URI, so that separate stories within one workspace—e.g., those within
+// sibling directories—do not share passages.  A project is rooted at the
+// nearest directory containing a project configuration file, if any, elsewise
+// at the outermost directory, within the workspace folder, which contains at
+// most one story—i.e., Twee source defining a StoryData passage.
+func (srv *server) projects() map[string]string {
+	// Count the stories within each directory, recursively.
+	stories := make(map[string]int)
+	for _, doc := range srv.docs {
+		if !doc.hasStoryData() {
+			continue
+		}
+		for dir := filepath.Dir(uriToPath(doc.uri)); ; dir = filepath.Dir(dir) {
+			stories[dir]++
+			if parent := filepath.Dir(dir); parent == dir {
+				break
+			}
+		}
+	}
+
+	projects := make(map[string]string, len(srv.docs))
+	for uri := range srv.docs {
+		dir := filepath.Dir(uriToPath(uri))
+		root := srv.workspaceRoot(dir)
+		project := dir
+		for ; ; dir = filepath.Dir(dir) {
+			if srv.configDirs[dir] {
+				project = dir
+				break
+			}
+			if stories[dir] <= 1 {
+				project = dir
+			}
+			if parent := filepath.Dir(dir); dir == root || parent == dir {
+				break
+			}
+		}
+		projects[uri] = project
+	}
+	return projects
+}
+
+// workspaceRoot returns the workspace folder containing the directory, if any,
+// elsewise the directory itself.
+func (srv *server) workspaceRoot(dir string) string {
+	for _, root := range srv.roots {
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root
+		}
+	}
+	return dir
+}
+
+// hasStoryData reports whether the document defines a StoryData passage.
+func (doc *document) hasStoryData() bool {
+	for _, p := range doc.passages {
+		if p.Name() == "StoryData" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Features.
+*/
+
+// completion returns the passage names as completions, if the position is
+// within the target of a link.
+func (srv *server) completion(params textDocumentPositionParams) []completionItem {
+	doc, ok := srv.docs[normalizeURI(params.TextDocument.URI)]
+	if !ok || params.Position.Line < 0 || params.Position.Line >= len(doc.lines) {
+		return nil
+	}
+	line := doc.lines[params.Position.Line]
+	cursor := byteOffset(line, params.Position.Character)
+
+	// Find the start of the unclosed link preceding the cursor, if any.
+	prefix := line[:cursor]
+	start := strings.LastIndex(prefix, "[[")
+	if start == -1 || strings.Contains(prefix[start:], "]]") {
+		return nil
+	}
+	start += 2
+
+	// Find the start of the target within the link.
+	if i := strings.LastIndex(prefix[start:], "|"); i != -1 {
+		start += i + 1
+	}
+	if i := strings.LastIndex(prefix[start:], "->"); i != -1 {
+		start += i + 2
+	}
+	if strings.Contains(prefix[start:], "<-") {
+		return nil
+	}
+
+	replace := textRange{
+		Start: position{params.Position.Line, utf16Column(line, start)},
+		End:   params.Position,
+	}
+	items := []completionItem{}
+	for _, uri := range srv.projectURIs(doc.uri) {
+		for _, p := range srv.docs[uri].passages {
+			items = append(items, completionItem{
+				Label:    p.Name(),
+				Kind:     completionItemKindReference,
+				Detail:   p.Position(),
+				TextEdit: &textEdit{Range: replace, NewText: p.Name()},
+			})
+		}
+	}
+	return items
+}
+
+// definition returns the locations of the passage targeted by the link at the
+// position, if any.
+func (srv *server) definition(params textDocumentPositionParams) []location {
+	doc, ok := srv.docs[normalizeURI(params.TextDocument.URI)]
+	if !ok || params.Position.Line < 0 || params.Position.Line >= len(doc.lines) {
+		return nil
+	}
+	line := doc.lines[params.Position.Line]
+	column := utf8.RuneCountInString(line[:byteOffset(line, params.Position.Character)]) + 1
+
+	// NOTE: Use the links of the parsed passages, so that links within comments
+	// and to URLs are excluded, as when compiling.
+	var target string
+	for _, p := range doc.passages {
+		for _, link := range p.Links() {
+			if link.Line == params.Position.Line+1 && link.Column <= column && column <= link.Column+link.Length {
+				target = link.Target
+				break
+			}
+		}
+		if target != "" {
+			break
+		}
+	}
+	if target == "" {
+		return nil
+	}
+
+	locations := []location{}
+	for _, uri := range srv.projectURIs(doc.uri) {
+		other := srv.docs[uri]
+		for _, p := range other.passages {
+			if p.Name() == target && p.Line() > 0 {
+				locations = append(locations, location{URI: uri, Range: other.lineRange(p.Line() - 1)})
+			}
+		}
+	}
+	return locations
+}
+
+// documentSymbols returns a symbol for each passage of the document.
+func (srv *server) documentSymbols(params documentSymbolParams) []documentSymbol {
+	doc, ok := srv.docs[normalizeURI(params.TextDocument.URI)]
+	if !ok {
+		return nil
+	}
+
+	symbols := []documentSymbol{}
+	for i, p := range doc.passages {
+		if p.Line() == 0 || p.Line() > len(doc.lines) {
+			continue
+		}
+
+		// A passage spans from its header to the line preceding the next
+		// header, if any, elsewise to the end of the document.
+		last := len(doc.lines) - 1
+		if i+1 < len(doc.passages) && doc.passages[i+1].Line() > p.Line() {
+			last = doc.passages[i+1].Line() - 2
+		}
+		header := doc.lineRange(p.Line() - 1)
+		symbols = append(symbols, documentSymbol{
+			Name:   p.Name(),
+			Detail: strings.Join(p.Tags(), " "),
+			Kind:   symbolKindString,
+			Range: textRange{
+				Start: header.Start,
+				End:   doc.lineRange(last).End,
+			},
+			SelectionRange: header,
+		})
+	}
+	return symbols
+}
+
+// publishDiagnostics publishes the diagnostics of every document.  As broken
+// links depend upon the passages of every document of the project, the
+// diagnostics of all documents are recomputed.
+func (srv *server) publishDiagnostics() error {
+	var (
+		projects = srv.projects()
+		uris     = make(map[string][]string) // URIs of the documents, keyed by project.
+		names    = make(map[string]map[string]bool)
+	)
+	for _, uri := range srv.sortedURIs() {
+		uris[projects[uri]] = append(uris[projects[uri]], uri)
+	}
+	for project := range uris {
+		names[project] = srv.passageNames(uris[project])
+	}
+	for _, uri := range srv.sortedURIs() {
+		diags := srv.docs[uri].diagnostics(names[projects[uri]])
+		if len(diags) == 0 && !srv.published[uri] {
+			continue
+		}
+		srv.published[uri] = len(diags) > 0
+		if err := srv.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diags}); err != nil {
+			return err
+		}
+	}
+
+	// Clear the diagnostics of removed documents.
+	for uri, published := range srv.published {
+		if _, ok := srv.docs[uri]; !ok {
+			delete(srv.published, uri)
+			if published {
+				if err := srv.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: []diagnostic{}}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// diagnostics returns the diagnostics of the document—i.e., its parse error,
+// if any, and its broken links.
+func (doc *document) diagnostics(names map[string]bool) []diagnostic {
+	diags := []diagnostic{}
+	if doc.err != nil {
+		var rng textRange
+		if doc.err.Line > 0 {
+			rng = doc.lineRange(doc.err.Line - 1)
+		}
+		diags = append(diags, diagnostic{
+			Range:    rng,
+			Severity: severityError,
+			Code:     doc.err.Code,
+			Source:   "tweego",
+			Message:  doc.err.Message,
+		})
+	}
+
+	for _, p := range doc.passages {
+		if !p.IsStoryPassage() {
+			continue
+		}
+		for _, link := range p.Links() {
+			if names[link.Target] || link.Line == 0 || link.Line > len(doc.lines) {
+				continue
+			}
+			line := doc.lines[link.Line-1]
+			start := runeOffset(line, link.Column)
+			end := runeOffset(line, link.Column+link.Length)
+			diags = append(diags, diagnostic{
+				Range: textRange{
+					Start: position{link.Line - 1, utf16Column(line, start)},
+					End:   position{link.Line - 1, utf16Column(line, end)},
+				},
+				Severity: severityWarning,
+				Code:     "broken-link",
+				Source:   "tweego",
+				Message:  "Passage \"" + p.Name() + "\" links to nonexistent passage \"" + link.Target + "\".",
+			})
+		}
+	}
+	return diags
+}
+
+// lineRange returns the range of the entire line (0-base).
+func (doc *document) lineRange(line int) textRange {
+	if line < 0 || line >= len(doc.lines) {
+		return textRange{}
+	}
+	return textRange{
+		Start: position{line, 0},
+		End:   position{line, utf16Column(doc.lines[line], len(doc.lines[line]))},
+	}
+}
+
+/*
+	URIs.
+*/
+
+// normalizeURI returns the file URI in the same form as pathToURI, so that
+// differences in percent-encoding—e.g., of `:` or spaces—between clients and
+// the workspace index don't yield duplicate documents.  Other URIs are returned
+// as-is.
+func normalizeURI(uri string) string {
+	if u, err := url.Parse(uri); err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return pathToURI(uriToPath(uri))
+}
+
+// uriToPath returns the filesystem path of the file URI.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		// E.g., `/C:/foo` → `C:/foo`.
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+// pathToURI returns the file URI of the filesystem path.
+func pathToURI(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// buildAllStories decompiles each story of the Twine 2 HTML sources—e.g.,
+// library archives—into its own directory within the output directory, named
+// after the story.
+func buildAllStories(c *config, opts *twee.Options) error {
+	stories, err := twee.ListStories(c.sourcePaths, opts)
+	if err != nil {
+		return err
+	}
+	if len(stories) == 0 {
+		return fmt.Errorf("Twine 2 stories not found within the input sources.")
+	}
+
+	var (
+		errs twee.Errors
+		used = make(map[string]bool)
+	)
+	for _, info := range stories {
+		storyOpts := *opts
+		storyOpts.Story = info.IFID
+		if storyOpts.Story == "" {
+			storyOpts.Story = info.Name
+		}
+
+		// Name the directory after the story, ensuring that no two differ only
+		// by case.
+		dirname := twee.SafeFilename(info.Name)
+		for n := 2; used[strings.ToLower(dirname)]; n++ {
+			dirname = fmt.Sprintf("%s (%d)", twee.SafeFilename(info.Name), n)
+		}
+		used[strings.ToLower(dirname)] = true
+		dirname = filepath.Join(c.outFile, dirname)
+
+		outFile := dirname
+		if c.splitBy == "" {
+			if err := os.MkdirAll(dirname, 0755); err != nil {
+				return err
+			}
+			outFile = filepath.Join(dirname, filepath.Base(dirname)+".tw")
+		}
+
+		s, err := twee.Load([]string{info.Filename}, &storyOpts)
+		if err == nil {
+			err = writeStory(c, s, &storyOpts, outFile)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Decompiled story %q to: %s", info.Name, relPath(outFile))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// formats the list of the stories within the input sources somewhat nicely for the user
+func usageStories(c *config) {
+	stories, err := twee.ListStories(c.sourcePaths, c.tweeOptions(nil))
+	if err != nil {
+		logErrors(err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	if len(stories) == 0 {
+		fmt.Fprintln(os.Stderr, "Twine 2 stories not found.")
+	} else {
+		fmt.Fprintln(os.Stderr, "Available stories:")
+		fmt.Fprintln(os.Stderr, "  IFID                                   Passages   Name [File]")
+		fmt.Fprintln(os.Stderr, "  ------------------------------------   --------   ------------------------------")
+		for _, info := range stories {
+			fmt.Fprintf(os.Stderr, "  %-36s   %8d   %s [%s]\n", info.IFID, info.Passages, info.Name, relPath(info.Filename))
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+	os.Exit(1)
+}
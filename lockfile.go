@@ -0,0 +1,159 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// Base name of the story format lockfile, within the working directory.
+const lockFileBasename = "tweego.lock"
+
+// lockFile is the story format lockfile, which records the story format—and,
+// separately, the proofing format—a build resolved to, so that builds
+// elsewhere—e.g., with other story formats installed—may be checked against it.
+type lockFile struct {
+	Format   *lockFileFormat `json:"format,omitempty"`
+	Proofing *lockFileFormat `json:"proofing,omitempty"`
+}
+
+// lockFileFormat is the story format recorded within the lockfile.
+type lockFileFormat struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	Path    string `json:"path"`   // Slash-separated, relative to the working directory if within it.
+	SHA256  string `json:"sha256"` // Hex-encoded SHA-256 of the story format's file.
+}
+
+// newLockFileFormat returns the lockfile record of the story format.
+func newLockFileFormat(f *twee.Format) (lockFileFormat, error) {
+	sum, err := f.Checksum()
+	if err != nil {
+		return lockFileFormat{}, err
+	}
+	path := relPath(f.Filename())
+	if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		if abs, err := filepath.Abs(f.Filename()); err == nil {
+			path = abs
+		}
+	}
+	return lockFileFormat{
+		ID:      f.ID(),
+		Name:    f.Name(),
+		Version: f.Version(),
+		Path:    filepath.ToSlash(path),
+		SHA256:  sum,
+	}, nil
+}
+
+// String returns the description of the story format used within messages.
+func (lf lockFileFormat) String() string {
+	if lf.Name != "" {
+		return fmt.Sprintf("%s (%s %s, sha256 %.12s…, %s)", lf.ID, lf.Name, lf.Version, lf.SHA256, lf.Path)
+	}
+	return fmt.Sprintf("%s (sha256 %.12s…, %s)", lf.ID, lf.SHA256, lf.Path)
+}
+
+// matches reports whether the story formats are the same—i.e., have the same
+// name, version, and contents.  The IDs and paths may differ between installs.
+func (lf lockFileFormat) matches(other lockFileFormat) bool {
+	return lf.Name == other.Name && lf.Version == other.Version && lf.SHA256 == other.SHA256
+}
+
+// loadLockFile loads the lockfile, returning nil if it does not exist.
+func loadLockFile(filename string) (*lockFile, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lf := &lockFile{}
+	if err := json.Unmarshal(data, lf); err != nil {
+		return nil, fmt.Errorf("Malformed lockfile; %s.", err.Error())
+	}
+	return lf, nil
+}
+
+// writeLockFile writes the lockfile.
+func writeLockFile(filename string, lf *lockFile) error {
+	data, err := json.MarshalIndent(lf, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// checkLockFile checks the story format the story was compiled with against
+// the lockfile, if any, or, if updating, records it within the lockfile.  Any
+// warning is passed to report, as with the rest of the build.  Only
+// compiled HTML and proofing copies, whose proofing format is recorded
+// separately, are checked.  A mismatch is an error when the lock is strict,
+// elsewise a warning.
+func checkLockFile(c *config, s *twee.Story, report func(*twee.Diagnostic)) error {
+	f := s.Format()
+	if f == nil || (c.outMode != twee.OutModeHTML && c.outMode != twee.OutModeProofing) {
+		return nil
+	}
+	resolved, err := newLockFileFormat(f)
+	if err != nil {
+		return fmt.Errorf("lockfile: %s", err.Error())
+	}
+
+	filename := filepath.Join(workingDir, lockFileBasename)
+	lf, err := loadLockFile(filename)
+	if err != nil && !c.updateLock {
+		return &twee.Diagnostic{Code: "lockfile", Message: err.Error(), Filename: lockFileBasename}
+	}
+	if lf == nil {
+		lf = &lockFile{}
+	}
+	locked, kind := &lf.Format, "Story"
+	if c.outMode == twee.OutModeProofing {
+		locked, kind = &lf.Proofing, "Proofing"
+	}
+
+	// Update the lockfile, if its record differs, so as not to rewrite it on
+	// every build—e.g., in watch mode.
+	if c.updateLock {
+		if *locked != nil && **locked == resolved {
+			return nil
+		}
+		*locked = &resolved
+		if err := writeLockFile(filename, lf); err != nil {
+			return fmt.Errorf("lockfile: %s", err.Error())
+		}
+		return nil
+	}
+
+	if *locked == nil || (*locked).matches(resolved) {
+		return nil
+	}
+
+	d := &twee.Diagnostic{
+		Severity: twee.SeverityWarning,
+		Code:     "lockfile",
+		Message:  fmt.Sprintf("%s format %s differs from the locked %s format %s.", kind, resolved, strings.ToLower(kind), **locked),
+		Filename: lockFileBasename,
+	}
+	if c.strictLock {
+		d.Severity = twee.SeverityError
+		return d
+	}
+	report(d)
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Cache holds the results of previous builds, so that repeated builds—e.g.,
+// within watch mode—only have to redo the work for those files which have
+// changed.  A cache must not be used by concurrent builds.
+type Cache struct {
+	walks map[string][]string // Filenames from walking lists of pathnames, keyed by list.
+	files map[string]*cacheFile
+}
+
+// cacheFile is the cached result of loading a source file.
+type cacheFile struct {
+	stamp    fileStamp
+	passages []*Passage // Passages loaded from the file, in order and as they were prior to being added to the story.
+}
+
+// fileStamp identifies a version of a file by its size and modification time.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fs fileStamp) equal(other fileStamp) bool {
+	return fs.size == other.size && fs.modTime.Equal(other.modTime)
+}
+
+// getFileStamp returns the stamp of the named file.
+func getFileStamp(filename string) (fileStamp, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// NewCache creates a new build cache instance.
+func NewCache() *Cache {
+	return &Cache{
+		walks: make(map[string][]string),
+		files: make(map[string]*cacheFile),
+	}
+}
+
+// Rescan discards the results of walking the filesystem, so that the next
+// build walks it again, along with the cached results of files which no longer
+// exist.  It should be called whenever files may have been created, removed,
+// or renamed.
+func (bc *Cache) Rescan() {
+	bc.walks = make(map[string][]string)
+	for filename := range bc.files {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			delete(bc.files, filename)
+		}
+	}
+}
+
+// getFilenames returns the filenames from walking the pathnames, only walking
+// the filesystem again if necessary.
+func (bc *Cache) getFilenames(pathnames []string, outFilename string, rep reporter) ([]string, error) {
+	key := strings.Join(pathnames, "\x00")
+	if filenames, ok := bc.walks[key]; ok {
+		return filenames, nil
+	}
+
+	filenames, err := getFilenames(pathnames, outFilename, rep)
+	if err != nil {
+		return nil, err
+	}
+	bc.walks[key] = filenames
+	return filenames, nil
+}
+
+// lookup returns the cached passages of the named file and its current stamp.
+// The passages are only returned if the file is unchanged since it was cached.
+func (bc *Cache) lookup(filename string) (passages []*Passage, stamp fileStamp, ok bool) {
+	stamp, err := getFileStamp(filename)
+	if err != nil {
+		delete(bc.files, filename)
+		return nil, fileStamp{}, false
+	}
+	if entry, found := bc.files[filename]; found && entry.stamp.equal(stamp) {
+		return entry.passages, stamp, true
+	}
+	return nil, stamp, false
+}
+
+// store caches the passages loaded from the named file.
+func (bc *Cache) store(filename string, stamp fileStamp, passages []*Passage) {
+	if stamp.modTime.IsZero() {
+		return
+	}
+	bc.files[filename] = &cacheFile{stamp: stamp, passages: passages}
+}
@@ -0,0 +1,146 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // The build failed.
+	SeverityWarning                 // The build succeeded, but something may be amiss.
+)
+
+// String returns the name of the severity—i.e., `error` or `warning`.
+func (sev Severity) String() string {
+	if sev == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// MarshalText encodes the severity as its name.
+func (sev Severity) MarshalText() ([]byte, error) {
+	return []byte(sev.String()), nil
+}
+
+// Diagnostic is a problem found while loading or compiling a story.  The
+// diagnostics of errors are returned as errors, while those of warnings are
+// reported, see Options.Report.
+//
+// The code identifies the kind of problem—e.g., `broken-link`—and, unlike
+// the message, is stable across releases.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code,omitempty"`    // Kind of the problem.
+	Message  string   `json:"message"`           // Problem message.
+	Filename string   `json:"file,omitempty"`    // Name of the source file; empty if unknown.
+	Line     int      `json:"line,omitempty"`    // Line within the source file (1-base); 0 if unknown.
+	Column   int      `json:"column,omitempty"`  // Column within the line (1-base, in characters); 0 if unknown.
+	Passage  string   `json:"passage,omitempty"` // Name of the passage; empty if unknown.
+}
+
+// newFileDiagnostic returns a diagnostic associated with the named file.
+func newFileDiagnostic(sev Severity, code, filename string, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: sev,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Filename: filename,
+	}
+}
+
+// newPassageDiagnostic returns a diagnostic associated with the passage header.
+func newPassageDiagnostic(sev Severity, code string, p *Passage, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: sev,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Filename: p.origin.filename,
+		Line:     p.origin.line,
+		Passage:  p.name,
+	}
+}
+
+// Position returns the position of the diagnostic as `filename:line:column`,
+// omitting the unknown parts, or an empty string if the file is unknown.
+func (d *Diagnostic) Position() string {
+	switch {
+	case d.Filename == "":
+		return ""
+	case d.Line == 0:
+		return d.Filename
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d", d.Filename, d.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", d.Filename, d.Line, d.Column)
+	}
+}
+
+// Error returns the message prefixed with its position—e.g.,
+// `chapter3.tw:212: …`.
+func (d *Diagnostic) Error() string {
+	if pos := d.Position(); pos != "" {
+		return pos + ": " + d.Message
+	}
+	return d.Message
+}
+
+// Errors is a list of errors which, together, caused a build to fail.
+type Errors []error
+
+// Error returns the error messages, one per line.
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// FlattenErrors returns the individual errors of err.
+func FlattenErrors(err error) []error {
+	if errs, ok := err.(Errors); ok {
+		return errs
+	}
+	return []error{err}
+}
+
+// ErrorDiagnostics returns the diagnostics of the individual errors of err.
+// Errors which are not diagnostics are given one without a code or position.
+func ErrorDiagnostics(err error) []*Diagnostic {
+	errs := FlattenErrors(err)
+	diags := make([]*Diagnostic, len(errs))
+	for i, err := range errs {
+		switch e := err.(type) {
+		case *Diagnostic:
+			diags[i] = e
+		case *FormatUnavailableError:
+			diags[i] = &Diagnostic{Severity: SeverityError, Code: "format-unavailable", Message: e.Error()}
+		default:
+			diags[i] = &Diagnostic{Severity: SeverityError, Message: e.Error()}
+		}
+	}
+	return diags
+}
+
+// reporter reports diagnostics.  A nil reporter logs them to the standard
+// logger.
+type reporter func(*Diagnostic)
+
+func (r reporter) report(d *Diagnostic) {
+	if r == nil {
+		log.Printf("%s: %s", d.Severity, d.Error())
+		return
+	}
+	r(d)
+}
@@ -4,7 +4,7 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	"strings"
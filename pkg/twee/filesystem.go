@@ -0,0 +1,135 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var workingDir string
+
+func init() {
+	// Attempt to get the working directory, failure is okay.
+	if wd, err := os.Getwd(); err == nil {
+		workingDir = wd
+	}
+}
+
+var errNoOutToIn = fmt.Errorf("no output to input source")
+
+// Walk the specified pathnames, collecting regular files.
+func getFilenames(pathnames []string, outFilename string, rep reporter) ([]string, error) {
+	var (
+		filenames  []string
+		absOutFile string
+	)
+	var fileWalker filepath.WalkFunc = func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if absolute == absOutFile {
+			return errNoOutToIn
+		}
+		relative, _ := filepath.Rel(workingDir, absolute) // Failure is okay.
+		if relative != "" {
+			filenames = append(filenames, relative)
+		} else {
+			filenames = append(filenames, absolute)
+		}
+		return nil
+	}
+
+	// Get the absolute output filename.
+	absOutFile, err := filepath.Abs(outFilename)
+	if err != nil {
+		return nil, fmt.Errorf("path %s: %s", outFilename, err.Error())
+	}
+
+	for _, pathname := range pathnames {
+		if pathname == "-" {
+			rep.report(newFileDiagnostic(SeverityWarning, "path", "-", "Reading from standard input is unsupported."))
+			continue
+		} else if err := filepath.Walk(pathname, fileWalker); err != nil {
+			if err == errNoOutToIn {
+				return nil, newFileDiagnostic(SeverityError, "path", pathname, "Output file cannot be an input source.")
+			} else {
+				rep.report(newFileDiagnostic(SeverityWarning, "path", pathname, "%s", err.Error()))
+				continue
+			}
+		}
+	}
+
+	return filenames, nil
+}
+
+// Filter the specified filenames, removing those which are, or are within,
+// any of the excluded pathnames.
+func excludeFilenames(filenames, excludePathnames []string, rep reporter) []string {
+	if len(excludePathnames) == 0 {
+		return filenames
+	}
+
+	var excludes []string
+	for _, pathname := range excludePathnames {
+		absolute, err := filepath.Abs(pathname)
+		if err != nil {
+			rep.report(newFileDiagnostic(SeverityWarning, "path", pathname, "%s", err.Error()))
+			continue
+		}
+		excludes = append(excludes, absolute)
+	}
+
+	var filtered []string
+FilenameLoop:
+	for _, filename := range filenames {
+		absolute, err := filepath.Abs(filename)
+		if err != nil {
+			absolute = filename
+		}
+		for _, exclude := range excludes {
+			if absolute == exclude || strings.HasPrefix(absolute, exclude+string(filepath.Separator)) {
+				continue FilenameLoop
+			}
+		}
+		filtered = append(filtered, filename)
+	}
+	return filtered
+}
+
+// KnownFileType reports whether the file is of a type which is loaded as an
+// input source or module.
+func KnownFileType(filename string) bool {
+	switch sourceFileExt(filename) {
+	// NOTE: The case values here should match those in `storyload.go:(*Story).load()`.
+	case "tw", "twee",
+		"tw2", "twee2",
+		"htm", "html",
+		"twine2.json",
+		"css",
+		"js",
+		"otf", "ttf", "woff", "woff2",
+		"gif", "jpeg", "jpg", "png", "svg", "tif", "tiff", "webp",
+		"aac", "flac", "m4a", "mp3", "oga", "ogg", "opus", "wav", "wave", "weba",
+		"mp4", "ogv", "webm",
+		"vtt":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Version of the story format metadata cache file; caches of other versions
+// are discarded.
+const formatCacheVersion = 1
+
+// formatCache is the story format metadata cache, which persists the metadata
+// of story formats in the Twine 2 style across runs.
+type formatCache struct {
+	filename string
+	loaded   map[string]formatCacheEntry // Entries loaded from the cache file.
+	used     map[string]formatCacheEntry // Entries of the story formats loaded this run, which replace those loaded.
+}
+
+type formatCacheJSON struct {
+	Version int                         `json:"version"`
+	Formats map[string]formatCacheEntry `json:"formats"` // Keyed by absolute filename.
+}
+
+// formatCacheEntry is the cached metadata of a story format, along with the
+// stamp of its file when the metadata was decoded.
+type formatCacheEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"` // Unix time, in nanoseconds.
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Proofing bool   `json:"proofing,omitempty"`
+}
+
+// loadFormatCache loads the story format metadata cache from the named file, if
+// possible, elsewise it returns an empty cache.
+func loadFormatCache(filename string) *formatCache {
+	cache := &formatCache{
+		filename: filename,
+		loaded:   make(map[string]formatCacheEntry),
+		used:     make(map[string]formatCacheEntry),
+	}
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		var cacheJSON formatCacheJSON
+		if err := json.Unmarshal(data, &cacheJSON); err == nil && cacheJSON.Version == formatCacheVersion && cacheJSON.Formats != nil {
+			cache.loaded = cacheJSON.Formats
+		}
+	}
+	return cache
+}
+
+// unmarshalMetadata populates the metadata of the story format from the cache,
+// if its file is unchanged, elsewise by decoding it.  A nil cache always
+// decodes.
+func (cache *formatCache) unmarshalMetadata(f *Format) error {
+	if cache == nil || !f.twine2 {
+		return f.unmarshalMetadata()
+	}
+
+	key, err := filepath.Abs(f.filename)
+	if err != nil {
+		return f.unmarshalMetadata()
+	}
+	stamp, err := getFileStamp(f.filename)
+	if err != nil {
+		return err
+	}
+
+	entry, ok := cache.loaded[key]
+	if !ok || entry.Size != stamp.size || entry.ModTime != stamp.modTime.UnixNano() {
+		if err := f.unmarshalMetadata(); err != nil {
+			return err
+		}
+		entry = formatCacheEntry{
+			Size:     stamp.size,
+			ModTime:  stamp.modTime.UnixNano(),
+			Name:     f.name,
+			Version:  f.version,
+			Proofing: f.proofing,
+		}
+	} else {
+		f.name = entry.Name
+		f.version = entry.Version
+		f.proofing = entry.Proofing
+	}
+	cache.used[key] = entry
+	return nil
+}
+
+// save writes the entries of the story formats loaded this run, along with the
+// entries loaded from the cache file for other story formats—e.g., those of
+// other projects—to the cache file, if they differ from those loaded from it.
+// Entries whose files no longer exist or have changed are pruned.  The file is
+// replaced, rather than rewritten, so that concurrent runs never see a partial
+// cache.
+func (cache *formatCache) save() {
+	formats := make(map[string]formatCacheEntry, len(cache.loaded)+len(cache.used))
+	for key, entry := range cache.loaded {
+		if _, ok := cache.used[key]; ok {
+			continue
+		}
+		if stamp, err := getFileStamp(key); err == nil && entry.Size == stamp.size && entry.ModTime == stamp.modTime.UnixNano() {
+			formats[key] = entry
+		}
+	}
+	for key, entry := range cache.used {
+		formats[key] = entry
+	}
+	if reflect.DeepEqual(cache.loaded, formats) {
+		return
+	}
+
+	data, err := json.Marshal(&formatCacheJSON{Version: formatCacheVersion, Formats: formats})
+	if err != nil {
+		return
+	}
+	dirname := filepath.Dir(cache.filename)
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(dirname, filepath.Base(cache.filename)+".*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cache.filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,272 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	// external packages
+	"github.com/Masterminds/semver/v3"
+)
+
+// formatArchiveFile is a file within a story format archive.
+type formatArchiveFile struct {
+	name string // Slash-separated path of the file, relative to the root of the archive.
+	open func() (io.ReadCloser, error)
+}
+
+// Matches characters which are not allowed within generated story format IDs.
+var formatIDRe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// InstallFormat installs the story format within the archive—either a zip file
+// or a directory—into the search directory, returning the installed story
+// format.  The archive must contain exactly one story format, either at its
+// root or within a top-level directory.  The story format is installed under
+// the name of its directory, if any, elsewise one derived from its name and
+// major version, suffixed as necessary so as not to collide with the IDs of
+// the installed story formats.
+func InstallFormat(pathname, searchDirname string, installed Formats) (*Format, error) {
+	files, closer, err := readFormatArchive(pathname)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	// Find the story format file.
+	dir, base, err := findFormatArchiveFile(files)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", pathname, err.Error())
+	}
+	baseID := path.Base(dir)
+	if dir == "." {
+		baseID = strings.TrimSuffix(filepath.Base(pathname), filepath.Ext(pathname))
+	}
+
+	// Validate the story format.
+	f := &Format{id: strings.ToLower(baseID), twine2: base == "format.js"}
+	var source []byte
+	for _, file := range files {
+		if file.name == path.Join(dir, base) {
+			if source, err = readFormatArchiveFile(file); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if err := f.validate(source); err != nil {
+		return nil, fmt.Errorf("%s: Invalid story format; %s", pathname, err.Error())
+	}
+	if f.twine2 {
+		for _, other := range installed {
+			if other.twine2 && other.name == f.name && other.version == f.version {
+				return nil, fmt.Errorf("%s: Story format %s (%s) is already installed as %q.", pathname, f.name, f.version, other.id)
+			}
+		}
+	}
+
+	// Choose a non-colliding ID.
+	if dir == "." && f.twine2 {
+		if v, err := semver.NewVersion(f.version); err == nil {
+			baseID = fmt.Sprintf("%s-%d", f.name, v.Major())
+		}
+	}
+	f.id = uniqueFormatID(baseID, f.version, searchDirname, installed)
+
+	// Copy the files of the story format.
+	formatDirname := filepath.Join(searchDirname, f.id)
+	for _, file := range files {
+		name := file.name
+		if dir != "." {
+			if !strings.HasPrefix(name, dir+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		data, err := readFormatArchiveFile(file)
+		if err != nil {
+			os.RemoveAll(formatDirname)
+			return nil, err
+		}
+		filename := filepath.Join(formatDirname, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			os.RemoveAll(formatDirname)
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			os.RemoveAll(formatDirname)
+			return nil, err
+		}
+	}
+	f.filename = filepath.Join(formatDirname, base)
+
+	return f, nil
+}
+
+// validate checks that the story format source is usable, populating the
+// metadata of story formats in the Twine 2 style.
+func (f *Format) validate(source []byte) error {
+	if !f.twine2 {
+		if len(bytes.TrimSpace(source)) == 0 {
+			return errors.New("Empty header.html.")
+		}
+		return nil
+	}
+
+	data, err := f.getStoryFormatData(source)
+	if err != nil {
+		return err
+	}
+	switch {
+	case data.Name == "":
+		return errors.New("Story format JSON chunk lacks a name.")
+	case data.Source == "":
+		return errors.New("Story format JSON chunk lacks a source.")
+	}
+	if _, err := semver.NewVersion(data.Version); err != nil {
+		return fmt.Errorf("Could not parse version %q.", data.Version)
+	}
+	f.name = data.Name
+	f.version = data.Version
+	f.proofing = data.Proofing
+	return nil
+}
+
+// uniqueFormatID returns the base ID, normalized, suffixed with the version and
+// then a number as necessary so as not to collide with the IDs of the installed
+// story formats or the directories within the search directory.
+func uniqueFormatID(base, version, searchDirname string, installed Formats) string {
+	base = strings.Trim(formatIDRe.ReplaceAllLiteralString(strings.ToLower(base), "-"), "-.")
+	if base == "" {
+		base = "format"
+	}
+	taken := func(id string) bool {
+		if _, ok := installed[id]; ok {
+			return true
+		}
+		_, err := os.Stat(filepath.Join(searchDirname, id))
+		return err == nil
+	}
+
+	if !taken(base) {
+		return base
+	}
+	if version != "" {
+		base += "-" + version
+	}
+	candidate := base
+	for n := 2; taken(candidate); n++ {
+		candidate = fmt.Sprintf("%s-%d", base, n)
+	}
+	return candidate
+}
+
+// readFormatArchive returns the files within the story format archive, along
+// with the closer of the zip file, if any.
+func readFormatArchive(pathname string) ([]formatArchiveFile, io.Closer, error) {
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []formatArchiveFile
+	if info.IsDir() {
+		err := filepath.Walk(pathname, func(filename string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(pathname, filename)
+			if err != nil {
+				return err
+			}
+			files = append(files, formatArchiveFile{
+				name: filepath.ToSlash(rel),
+				open: func() (io.ReadCloser, error) { return os.Open(filename) },
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return files, nil, nil
+	}
+
+	r, err := zip.OpenReader(pathname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: Not a zip file or directory; %s.", pathname, err.Error())
+	}
+	for _, zf := range r.File {
+		name := path.Clean(zf.Name)
+		switch {
+		case zf.FileInfo().IsDir(), strings.HasPrefix(name, "__MACOSX/"):
+			continue
+		case path.IsAbs(name), name == "..", strings.HasPrefix(name, "../"):
+			r.Close()
+			return nil, nil, fmt.Errorf("%s: Unsafe path %q within zip file.", pathname, zf.Name)
+		}
+		files = append(files, formatArchiveFile{name: name, open: zf.Open})
+	}
+	return files, r, nil
+}
+
+// findFormatArchiveFile returns the directory and base name of the story format
+// file within the archive—i.e., its `format.js` or, for story formats in the
+// Twine 1 style, `header.html`.
+func findFormatArchiveFile(files []formatArchiveFile) (string, string, error) {
+	found := make(map[string]string)
+	for _, file := range files {
+		dir, base := path.Split(file.name)
+		dir = path.Clean(dir)
+		if strings.Contains(dir, "/") {
+			continue
+		}
+		switch base {
+		case "format.js":
+			found[dir] = base
+		case "header.html":
+			if found[dir] == "" {
+				found[dir] = base
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", "", errors.New("Story format not found; no format.js or header.html file at the root or within a top-level directory.")
+	case 1:
+		for dir, base := range found {
+			return dir, base, nil
+		}
+	}
+	dirs := make([]string, 0, len(found))
+	for dir := range found {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return "", "", fmt.Errorf("Multiple story formats found, within: %s.", strings.Join(dirs, ", "))
+}
+
+func readFormatArchiveFile(file formatArchiveFile) ([]byte, error) {
+	rc, err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,420 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	// external packages
+	"github.com/Masterminds/semver/v3"
+)
+
+type twine2FormatJSON struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Description string `json:"description"`
+	// Author      string `json:"author"`
+	// Image       string `json:"image"`
+	// URL string `json:"url"`
+	// License     string `json:"license"`
+	Proofing bool   `json:"proofing"`
+	Source   string `json:"source"`
+	// Setup       string `json:"-"`
+}
+
+// Format is a story format.
+type Format struct {
+	id       string
+	filename string
+	twine2   bool
+	name     string
+	version  string
+	proofing bool
+
+	// Decoded source cache, see `source()`.
+	sourceStamp fileStamp
+	sourceCache []byte
+}
+
+// ID returns the ID of the story format—i.e., the name of its directory.
+func (f *Format) ID() string {
+	return f.id
+}
+
+// Name returns the name of the story format; only for story formats in the
+// Twine 2 style.
+func (f *Format) Name() string {
+	return f.name
+}
+
+// Version returns the version of the story format; only for story formats in
+// the Twine 2 style.
+func (f *Format) Version() string {
+	return f.version
+}
+
+// Filename returns the name of the story format's file—i.e., its `format.js`
+// or `header.html` file.
+func (f *Format) Filename() string {
+	return f.filename
+}
+
+// Checksum returns the hex-encoded SHA-256 of the story format's file.
+func (f *Format) Checksum() (string, error) {
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// IsProofing reports whether the story format is a proofing format; only for
+// story formats in the Twine 2 style.
+func (f *Format) IsProofing() bool {
+	return f.proofing
+}
+
+// IsTwine1Style reports whether the story format is in the Twine 1 style.
+func (f *Format) IsTwine1Style() bool {
+	return !f.twine2
+}
+
+// IsTwine2Style reports whether the story format is in the Twine 2 style.
+func (f *Format) IsTwine2Style() bool {
+	return f.twine2
+}
+
+func (f *Format) getStoryFormatData(source []byte) (*twine2FormatJSON, error) {
+	if !f.twine2 {
+		return nil, errors.New("Not a Twine 2 style story format.")
+	}
+
+	// get the JSON chunk from the source
+	first := bytes.Index(source, []byte("{"))
+	last := bytes.LastIndex(source, []byte("}"))
+	if first == -1 || last == -1 {
+		return nil, errors.New("Could not find Twine 2 style story format JSON chunk.")
+	}
+	source = source[first : last+1]
+
+	// parse the JSON
+	data := &twine2FormatJSON{}
+	if err := json.Unmarshal(source, data); err != nil {
+		/*
+			START Harlowe malformed JSON chunk workaround
+
+			TODO: Remove this workaround that attempts to handle Harlowe's
+			broken JSON chunk.
+
+			NOTE: This worksaround is only possible because, currently,
+			Harlowe's "setup" property is the last entry in the chunk.
+		*/
+		if strings.HasPrefix(strings.ToLower(f.id), "harlowe") {
+			if i := bytes.LastIndex(source, []byte(`,"setup": function`)); i != -1 {
+				// cut the "setup" property and its invalid value
+				j := len(source) - 1
+				source = append(source[:i], source[j:]...)
+				return f.getStoryFormatData(source)
+			}
+		}
+		/*
+			If we've reached this point, either the format is not Harlowe
+			or we cannot find the start of its "setup" property, so just
+			return the JSON decoding error as normal.
+
+			END Harlowe malformed JSON chunk workaround
+		*/
+
+		return nil, errors.New("Could not decode story format JSON chunk.")
+	}
+
+	return data, nil
+}
+
+func (f *Format) unmarshalMetadata() error {
+	if !f.twine2 {
+		return nil
+	}
+
+	var (
+		data   *twine2FormatJSON
+		source []byte
+		err    error
+	)
+
+	// read in the story format
+	if source, err = fileReadAllAsUTF8(f.filename); err != nil {
+		return err
+	}
+
+	// load various bits of metadata from the JSON
+	if data, err = f.getStoryFormatData(source); err != nil {
+		return err
+	}
+	f.name = data.Name
+	f.version = data.Version
+	f.proofing = data.Proofing
+
+	return nil
+}
+
+func (f *Format) source() ([]byte, error) {
+	var (
+		source []byte
+		err    error
+	)
+
+	// reuse the cached source, if the story format is unchanged since it was decoded
+	stamp, stampErr := getFileStamp(f.filename)
+	if stampErr == nil && f.sourceCache != nil && stamp.equal(f.sourceStamp) {
+		return append([]byte(nil), f.sourceCache...), nil
+	}
+
+	// read in the story format
+	if source, err = fileReadAllAsUTF8(f.filename); err != nil {
+		return nil, fmt.Errorf("format %s", err.Error())
+	}
+
+	// if in Twine 2 style, extract the actual source from the JSON
+	if f.twine2 {
+		var data *twine2FormatJSON
+		if data, err = f.getStoryFormatData(source); err != nil {
+			return nil, fmt.Errorf("format %s: %s", f.id, err.Error())
+		}
+		source = []byte(data.Source)
+	}
+
+	// cache the decoded source
+	if stampErr == nil {
+		f.sourceStamp = stamp
+		f.sourceCache = append([]byte(nil), source...)
+	}
+
+	return source, nil
+}
+
+// Formats are story formats, keyed by ID.
+type Formats map[string]*Format
+
+// LoadFormats enumerates the story formats within the search paths.  Story
+// formats found within later search paths replace those with the same ID
+// found within earlier ones.  Story formats which cannot be loaded are skipped,
+// with a warning diagnostic passed to report—if nil, they're written to the
+// standard logger, as with Options.Report.
+func LoadFormats(searchPaths []string, report func(*Diagnostic)) Formats {
+	return loadFormats(searchPaths, nil, report)
+}
+
+// LoadFormatsWithCache is LoadFormats, save that the metadata of the story
+// formats is cached within the named file across runs, so that only story
+// formats which are new or changed since the last run—by size and modification
+// time—need be decoded.  Failing to read or write the cache file is not an
+// error, it's simply ignored.
+func LoadFormatsWithCache(searchPaths []string, cacheFilename string, report func(*Diagnostic)) Formats {
+	cache := loadFormatCache(cacheFilename)
+	formats := loadFormats(searchPaths, cache, report)
+	cache.save()
+	return formats
+}
+
+func loadFormats(searchPaths []string, cache *formatCache, rep reporter) Formats {
+	var (
+		baseFilenames = []string{"format.js", "header.html"}
+		formats       = make(Formats)
+	)
+
+	for _, searchDirname := range searchPaths {
+		if info, err := os.Stat(searchDirname); err != nil || !info.IsDir() {
+			continue
+		}
+
+		d, err := os.Open(searchDirname)
+		if err != nil {
+			continue
+		}
+
+		baseDirnames, err := d.Readdirnames(0)
+		if err != nil {
+			continue
+		}
+
+		for _, baseDirname := range baseDirnames {
+			formatDirname := filepath.Join(searchDirname, baseDirname)
+			if info, err := os.Stat(formatDirname); err != nil || !info.IsDir() {
+				continue
+			}
+
+			for _, baseFilename := range baseFilenames {
+				formatFilename := filepath.Join(formatDirname, baseFilename)
+				if info, err := os.Stat(formatFilename); err == nil && info.Mode().IsRegular() {
+					f := &Format{
+						id:       baseDirname,
+						filename: formatFilename,
+						twine2:   baseFilename == "format.js",
+					}
+					if err := cache.unmarshalMetadata(f); err != nil {
+						rep.report(newFileDiagnostic(SeverityWarning, "format", formatFilename, "Skipping story format %q; %s", f.id, err.Error()))
+						continue
+					}
+					formats[baseDirname] = f
+					break
+				}
+			}
+		}
+	}
+
+	return formats
+}
+
+func (m Formats) getIDFromTwine2Name(name string) string {
+	var (
+		found *semver.Version
+		id    string
+	)
+
+	for _, f := range m {
+		if !f.twine2 {
+			continue
+		}
+
+		if f.name == name {
+			if have, err := semver.NewVersion(f.version); err == nil {
+				if found == nil || have.GreaterThan(found) {
+					found = have
+					id = f.id
+				}
+			}
+		}
+	}
+
+	return id
+}
+
+func (m Formats) getIDFromTwine2NameAndVersion(name, version string) string {
+	id, _ := m.selectTwine2NameAndVersion(name, version)
+	return id
+}
+
+// selectTwine2NameAndVersion returns the ID of the greatest version of the named
+// story format which satisfies the wanted version, see `newTwine2VersionFilter()`,
+// along with why each of the other versions was rejected.  If the wanted version
+// cannot be parsed, the greatest version is selected.
+func (m Formats) selectTwine2NameAndVersion(name, version string) (string, []string) {
+	var (
+		found    *semver.Version
+		id       string
+		rejected []string
+	)
+	filter, filterErr := newTwine2VersionFilter(version)
+
+	ids := m.IDs()
+	sort.Strings(ids)
+	for _, fid := range ids {
+		f := m[fid]
+		if !f.twine2 || f.name != name {
+			continue
+		}
+
+		have, err := semver.NewVersion(f.version)
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s (%s): Could not parse version.", f.id, f.version))
+			continue
+		}
+		if filterErr == nil {
+			if err := filter(have); err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s (%s): %s.", f.id, f.version, err.Error()))
+				continue
+			}
+		}
+		if found == nil || have.GreaterThan(found) {
+			found = have
+			id = f.id
+		}
+	}
+
+	return id, rejected
+}
+
+// newTwine2VersionFilter returns a function checking whether story format
+// versions satisfy the wanted version—either a version, which is satisfied by
+// greater or equal versions of the same major version, or a SemVer constraint
+// expression—e.g., `~2.36`, `>=2.30 <2.37`, or `^2, !=2.33.0`.
+func newTwine2VersionFilter(wanted string) (func(*semver.Version) error, error) {
+	if v, err := semver.NewVersion(wanted); err == nil {
+		return func(have *semver.Version) error {
+			switch {
+			case have.Major() != v.Major():
+				return fmt.Errorf("%s is not of major version %d", have, v.Major())
+			case have.LessThan(v):
+				return fmt.Errorf("%s is less than %s", have, v)
+			}
+			return nil
+		}, nil
+	}
+
+	c, err := semver.NewConstraint(wanted)
+	if err != nil {
+		return nil, err
+	}
+	return func(have *semver.Version) error {
+		if ok, errs := c.Validate(have); !ok {
+			reasons := make([]string, 0, len(errs))
+			for _, err := range errs {
+				reasons = append(reasons, err.Error())
+			}
+			return errors.New(strings.Join(reasons, "; "))
+		}
+		return nil
+	}, nil
+}
+
+func (m Formats) hasByID(id string) bool {
+	_, ok := m[id]
+	return ok
+}
+
+func (m Formats) hasByTwine2Name(name string) bool {
+	_, ok := m[m.getIDFromTwine2Name(name)]
+	return ok
+}
+
+func (m Formats) hasByTwine2NameAndVersion(name, version string) bool {
+	_, ok := m[m.getIDFromTwine2NameAndVersion(name, version)]
+	return ok
+}
+
+func (m Formats) getByID(id string) *Format {
+	return m[id]
+}
+
+func (m Formats) getByTwine2Name(name string) *Format {
+	return m[m.getIDFromTwine2Name(name)]
+}
+
+func (m Formats) getByTwine2NameAndVersion(name, version string) *Format {
+	return m[m.getIDFromTwine2NameAndVersion(name, version)]
+}
+
+// IDs returns the IDs of the story formats, in no particular order.
+func (m Formats) IDs() []string {
+	var ids []string
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -4,7 +4,7 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	// standard packages
@@ -26,6 +26,21 @@ func getElementByTag(node *html.Node, tag string) *html.Node {
 	return getElementByIDAndTag(node, "", tag)
 }
 
+// getElementsByTag returns all elements with the tag, in document order.
+func getElementsByTag(node *html.Node, tag string) []*html.Node {
+	var elements []*html.Node
+	if node == nil {
+		return elements
+	}
+	if node.Type == html.ElementNode && node.Data == tag {
+		elements = append(elements, node)
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		elements = append(elements, getElementsByTag(child, tag)...)
+	}
+	return elements
+}
+
 func getElementByIDAndTag(node *html.Node, idPat, tag string) *html.Node {
 	if node == nil {
 		return nil
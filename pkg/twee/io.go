@@ -0,0 +1,176 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"unicode/utf8"
+	// external packages
+	"github.com/paulrosania/go-charset/charset"
+	_ "github.com/paulrosania/go-charset/data" // import the charset data
+)
+
+const (
+	// Assumed encoding of input files which are not UTF-8 encoded.
+	FallbackCharset = "windows-1252" // match case from "charset" packages
+
+	// Record separators.
+	recordSeparatorLF   = "\n"   // I.e., UNIX-y OSes.
+	recordSeparatorCRLF = "\r\n" // I.e., DOS/Windows.
+	recordSeparatorCR   = "\r"   // I.e., MacOS ≤9.
+
+	utfBOM = "\uFEFF"
+)
+
+func fileReadAllAsBase64(filename string) ([]byte, error) {
+	var (
+		r    io.Reader
+		data []byte
+		err  error
+	)
+	if filename == "-" {
+		r = os.Stdin
+	} else {
+		var f *os.File
+		if f, err = os.Open(filename); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, base64.StdEncoding.EncodedLen(len(data))) // try to avoid additional allocations
+	base64.StdEncoding.Encode(buf, data)
+	return buf, nil
+}
+
+// ReadFile reads the named file, as the compiler reads its sources—i.e., the
+// file is converted from the given charset to UTF-8, its BOM is removed, and
+// its record separators are normalized to line feeds.  If the charset is empty,
+// UTF-8 is assumed, with a fallback to FallbackCharset for invalid UTF-8.
+func ReadFile(filename, encoding string) ([]byte, error) {
+	return fileReadAllWithEncoding(filename, encoding, nil)
+}
+
+func fileReadAllAsUTF8(filename string) ([]byte, error) {
+	return fileReadAllWithEncoding(filename, "utf-8", nil)
+}
+
+func fileReadAllWithEncoding(filename, encoding string, rep reporter) ([]byte, error) {
+	var (
+		r      io.Reader
+		data   []byte
+		rsLF   = []byte(recordSeparatorLF)
+		rsCRLF = []byte(recordSeparatorCRLF)
+		rsCR   = []byte(recordSeparatorCR)
+		err    error
+	)
+
+	// Read in the entire file.
+	if filename == "-" {
+		r = os.Stdin
+	} else {
+		var f *os.File
+		if f, err = os.Open(filename); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+
+	// Convert the charset to UTF-8, if necessary.
+	encoding = charset.NormalizedName(encoding)
+	if utf8.Valid(data) {
+		switch encoding {
+		case "", "utf-8", "utf8", "ascii", "us-ascii":
+			// no-op
+		default:
+			rep.report(newFileDiagnostic(SeverityWarning, "charset", filename, "Already valid UTF-8; skipping charset conversion."))
+		}
+	} else {
+		switch encoding {
+		case "utf-8", "utf8", "ascii", "us-ascii":
+			rep.report(newFileDiagnostic(SeverityWarning, "charset", filename, "Invalid UTF-8; assuming charset is %s.", FallbackCharset))
+			fallthrough
+		case "":
+			encoding = charset.NormalizedName(FallbackCharset)
+		}
+		if r, err = charset.NewReader(encoding, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(data) {
+			return nil, newFileDiagnostic(SeverityError, "charset", filename, "Charset conversion yielded invalid UTF-8.")
+		}
+	}
+
+	// Strip the UTF BOM (\uFEFF), if it exists.
+	if bytes.Equal(data[:3], []byte(utfBOM)) {
+		data = data[3:]
+	}
+
+	// Normalize record separators.
+	data = bytes.Replace(data, rsCRLF, rsLF, -1)
+	data = bytes.Replace(data, rsCR, rsLF, -1)
+
+	return data, nil
+}
+
+func alignRecordSeparators(data []byte) []byte {
+	switch runtime.GOOS {
+	case "windows":
+		return bytes.Replace(data, []byte(recordSeparatorLF), []byte(recordSeparatorCRLF), -1)
+	default:
+		return data
+	}
+}
+
+func (s *Story) modifyHead(data []byte, modulePaths []string, headFile, encoding string) ([]byte, error) {
+	var headTags [][]byte
+
+	if len(modulePaths) > 0 {
+		source, err := s.loadModules(modulePaths, encoding)
+		if err != nil {
+			return nil, err
+		}
+		source = bytes.TrimSpace(source)
+		if len(source) > 0 {
+			headTags = append(headTags, source)
+		}
+	}
+
+	if headFile != "" {
+		if source, err := fileReadAllWithEncoding(headFile, encoding, s.reporter); err == nil {
+			source = bytes.TrimSpace(source)
+			if len(source) > 0 {
+				headTags = append(headTags, source)
+			}
+			s.externalFiles = append(s.externalFiles, headFile)
+		} else {
+			return nil, newFileDiagnostic(SeverityError, "load", headFile, "%s", err.Error())
+		}
+	}
+
+	if len(headTags) > 0 {
+		headTags = append(headTags, []byte("</head>"))
+		return bytes.Replace(data, []byte("</head>"), bytes.Join(headTags, []byte("\n")), 1), nil
+	}
+	return data, nil
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"strings"
+	// external packages
+	"golang.org/x/net/html"
+)
+
+/*
+	Twine 2 library archives—i.e., HTML files containing the story data chunks
+	of multiple stories, as exported by Twine 2's "Archive" command.
+*/
+
+// StoryInfo describes a story within a Twine 2 HTML file.
+type StoryInfo struct {
+	Filename string // Name of the HTML file.
+	Name     string // Name of the story.
+	IFID     string // IFID of the story.
+	Passages int    // Count of the passages of the story, sans its stylesheet and script.
+}
+
+// ListStories walks the pathnames, returning the stories within the Twine 2 HTML
+// files found—e.g., those within library archives.  Of the options, only
+// Encoding, ExcludePaths, OutFile, and Report are used.
+func ListStories(pathnames []string, opts *Options) ([]StoryInfo, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	filenames, err := opts.getFilenames(pathnames)
+	if err != nil {
+		return nil, err
+	}
+
+	var stories []StoryInfo
+	for _, filename := range filenames {
+		switch normalizedFileExt(filename) {
+		case "htm", "html":
+		default:
+			continue
+		}
+
+		source, err := fileReadAllWithEncoding(filename, opts.Encoding, opts.Report)
+		if err != nil {
+			return nil, newFileDiagnostic(SeverityError, "load", filename, "%s", err.Error())
+		}
+		doc, err := getDocumentTree(bytes.TrimSpace(source))
+		if err != nil {
+			return nil, newFileDiagnostic(SeverityError, "malformed-html", filename, "Malformed HTML source; %s.", err.Error())
+		}
+		for _, storyData := range getElementsByTag(doc, "tw-storydata") {
+			stories = append(stories, newStoryInfo(filename, storyData))
+		}
+	}
+	return stories, nil
+}
+
+func newStoryInfo(filename string, storyData *html.Node) StoryInfo {
+	info := StoryInfo{Filename: filename}
+	for _, a := range storyData.Attr {
+		switch a.Key {
+		case "name":
+			info.Name = a.Val
+		case "ifid":
+			info.IFID = strings.ToUpper(a.Val)
+		}
+	}
+	for node := storyData.FirstChild; node != nil; node = node.NextSibling {
+		if node.Type == html.ElementNode && node.Data == "tw-passagedata" {
+			info.Passages++
+		}
+	}
+	return info
+}
+
+// selectStoryData returns the index of the story data chunk selected by the
+// selector—i.e., the name or IFID of its story.  Files containing a single
+// story need no selector.  Lacking a selector, the first story is selected,
+// with a warning.
+func selectStoryData(filename string, stories []*html.Node, selector string, rep reporter) (int, error) {
+	if len(stories) == 1 {
+		return 0, nil
+	}
+	if selector == "" {
+		info := newStoryInfo(filename, stories[0])
+		rep.report(newFileDiagnostic(SeverityWarning, "story-selection", filename, "Library archive contains %d stories; loading the first, %q.  Select one by name or IFID via --story, see --list-stories.", len(stories), info.Name))
+		return 0, nil
+	}
+
+	selected := -1
+	for i, storyData := range stories {
+		info := newStoryInfo(filename, storyData)
+		if info.IFID != "" && strings.EqualFold(info.IFID, selector) {
+			return i, nil
+		}
+		if info.Name == selector {
+			if selected != -1 {
+				return -1, newFileDiagnostic(SeverityError, "story-selection", filename, "Library archive contains multiple stories named %q; select one by IFID.", selector)
+			}
+			selected = i
+		}
+	}
+	if selected == -1 {
+		return -1, newFileDiagnostic(SeverityError, "story-selection", filename, "Story %q not found within the library archive.", selector)
+	}
+	return selected, nil
+}
+
+// indexNth returns the index of the nth (0-base) instance of sep within s, or
+// -1 if there are fewer instances.
+func indexNth(s, sep []byte, n int) int {
+	offset := 0
+	for ; n >= 0; n-- {
+		i := bytes.Index(s[offset:], sep)
+		if i == -1 {
+			return -1
+		}
+		if n == 0 {
+			return offset + i
+		}
+		offset += i + len(sep)
+	}
+	return -1
+}
@@ -0,0 +1,193 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// Matches link markup—i.e., `[[…]]`.  Links may not span lines.
+	linkRe = regexp.MustCompile(`\[\[(.*?)\]\]`)
+
+	// Matches comment blocks, which are blanked out prior to link extraction.
+	linkCommentRe = regexp.MustCompile(`(?s:/%.*?%/|/\*.*?\*/|<!--.*?-->)`)
+)
+
+// passageLink is a link found within the text of a passage.
+type passageLink struct {
+	text   string // Text of the link.
+	target string // Name of the linked passage.
+	line   int    // Line within the passage text (0-base) of the link.
+	column int    // Column within the line (1-base, in characters) of the link.
+	length int    // Length (in characters) of the link markup.
+}
+
+// links returns the links found within the passage text, in order, see
+// LinkTarget.  Links whose target is a URL are discarded.
+func (p *Passage) links() []passageLink {
+	// Blank out comments, while preserving newlines, so that any links
+	// within them are neither reported nor throw off the line counts.
+	text := linkCommentRe.ReplaceAllStringFunc(p.text, func(comment string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '\n' {
+				return r
+			}
+			return ' '
+		}, comment)
+	})
+
+	var links []passageLink
+	for _, loc := range linkRe.FindAllStringSubmatchIndex(text, -1) {
+		linkText, target := linkParts(text[loc[2]:loc[3]])
+		if target == "" || strings.Contains(target, "://") {
+			continue
+		}
+		lineStart := strings.LastIndex(text[:loc[0]], "\n") + 1
+		links = append(links, passageLink{
+			text:   linkText,
+			target: target,
+			line:   strings.Count(text[:loc[0]], "\n"),
+			column: utf8.RuneCountInString(text[lineStart:loc[0]]) + 1,
+			length: utf8.RuneCountInString(text[loc[0]:loc[1]]),
+		})
+	}
+	return links
+}
+
+// Link is a link found within the text of a passage.
+type Link struct {
+	Text   string // Text of the link.
+	Target string // Name of the linked passage.
+	Line   int    // Line within the source file (1-base) of the link; 0 if unknown.
+	Column int    // Column within the line (1-base, in characters) of the link; 0 if unknown.
+	Length int    // Length (in characters) of the link markup—i.e., from `[[` through `]]`.
+}
+
+// Links returns the links found within the passage text, in order, see
+// LinkTarget.  Links within comments and links to URLs are excluded.
+func (p *Passage) Links() []Link {
+	var links []Link
+	for _, link := range p.links() {
+		line, column := p.linkPosition(link)
+		links = append(links, Link{Text: link.text, Target: link.target, Line: line, Column: column, Length: link.length})
+	}
+	return links
+}
+
+// linkPosition returns the line and column within the source file of the link,
+// or zeros if unknown.
+func (p *Passage) linkPosition(link passageLink) (line, column int) {
+	if p.origin.textLine == 0 {
+		return 0, 0
+	}
+	column = link.column
+	if link.line == 0 {
+		column += p.origin.textCol
+	}
+	return p.origin.textLine + link.line, column
+}
+
+// LinkTarget returns the passage name targeted by the given link markup
+// contents—i.e., the text between the opening `[[` and closing `]]`.
+//
+// The following link forms are supported:
+//
+//	[[target]]
+//	[[text|target]]
+//	[[text->target]]
+//	[[target<-text]]
+//
+// Any trailing setter component—e.g., `[[text|target][$x to 1]]`—is discarded.
+func LinkTarget(markup string) string {
+	_, target := linkParts(markup)
+	return target
+}
+
+// linkParts returns the text and target of the given link markup contents, see
+// LinkTarget.
+func linkParts(markup string) (text, target string) {
+	// Discard the setter component, if any.
+	if i := strings.Index(markup, "]["); i != -1 {
+		markup = markup[:i]
+	}
+
+	// Arrow links: the rightmost `->` and the leftmost `<-` are the dividers.
+	if i := strings.LastIndex(markup, "->"); i != -1 {
+		return strings.TrimSpace(markup[:i]), strings.TrimSpace(markup[i+2:])
+	}
+	if i := strings.Index(markup, "<-"); i != -1 {
+		return strings.TrimSpace(markup[i+2:]), strings.TrimSpace(markup[:i])
+	}
+
+	// Pipe links.
+	if i := strings.Index(markup, "|"); i != -1 {
+		return strings.TrimSpace(markup[:i]), strings.TrimSpace(markup[i+1:])
+	}
+
+	// Simple links.
+	target = strings.TrimSpace(markup)
+	return target, target
+}
+
+// brokenLinks returns a diagnostic for each link, within the story passages,
+// whose target passage does not exist.
+func (s *Story) brokenLinks(sev Severity) []*Diagnostic {
+	names := make(map[string]bool, len(s.passages))
+	for _, p := range s.passages {
+		names[p.name] = true
+	}
+
+	var broken []*Diagnostic
+	for _, p := range s.passages {
+		if !p.IsStoryPassage() {
+			continue
+		}
+
+		for _, link := range p.links() {
+			if names[link.target] {
+				continue
+			}
+
+			d := newPassageDiagnostic(sev, "broken-link", p, "Passage %q links to nonexistent passage %q.", p.name, link.target)
+			if line, column := p.linkPosition(link); line != 0 {
+				d.Line, d.Column = line, column
+			}
+			broken = append(broken, d)
+		}
+	}
+	return broken
+}
+
+// checkLinks checks the story passages for broken links.  If strict is
+// enabled, they're returned as errors, elsewise they're reported as warnings.
+func (s *Story) checkLinks(strict bool) error {
+	sev := SeverityWarning
+	if strict {
+		sev = SeverityError
+	}
+	broken := s.brokenLinks(sev)
+	if len(broken) == 0 {
+		return nil
+	}
+
+	if !strict {
+		for _, d := range broken {
+			s.report(d)
+		}
+		return nil
+	}
+
+	errs := make(Errors, 0, len(broken)+1)
+	for _, d := range broken {
+		errs = append(errs, d)
+	}
+	return append(errs, fmt.Errorf("Found %d broken passage link(s).", len(broken)))
+}
@@ -4,17 +4,16 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"path/filepath"
 	"strings"
 )
 
-func loadModules(filenames []string, encoding string) []byte {
+func (s *Story) loadModules(filenames []string, encoding string) ([]byte, error) {
 	var (
 		processedModules = make(map[string]bool)
 		headTags         [][]byte
@@ -22,7 +21,7 @@ func loadModules(filenames []string, encoding string) []byte {
 
 	for _, filename := range filenames {
 		if processedModules[filename] {
-			log.Printf("warning: load %s: Skipping duplicate.", filename)
+			s.report(newFileDiagnostic(SeverityWarning, "duplicate-file", filename, "Skipping duplicate."))
 			continue
 		}
 
@@ -31,11 +30,11 @@ func loadModules(filenames []string, encoding string) []byte {
 			err    error
 		)
 		switch normalizedFileExt(filename) {
-		// NOTE: The case values here should match those in `filesystem.go:knownFileType()`.
+		// NOTE: The case values here should match those in `filesystem.go:KnownFileType()`.
 		case "css":
-			source, err = loadModuleTagged("style", filename, encoding)
+			source, err = loadModuleTagged("style", filename, encoding, s.reporter)
 		case "js":
-			source, err = loadModuleTagged("script", filename, encoding)
+			source, err = loadModuleTagged("script", filename, encoding, s.reporter)
 		case "otf", "ttf", "woff", "woff2":
 			source, err = loadModuleFont(filename)
 		default:
@@ -43,20 +42,20 @@ func loadModules(filenames []string, encoding string) []byte {
 			continue
 		}
 		if err != nil {
-			log.Fatalf("error: load %s: %s", filename, err.Error())
+			return nil, newFileDiagnostic(SeverityError, "load", filename, "%s", err.Error())
 		}
 		if len(source) > 0 {
 			headTags = append(headTags, source)
 		}
 		processedModules[filename] = true
-		statsAddExternalFile(filename)
+		s.externalFiles = append(s.externalFiles, filename)
 	}
 
-	return bytes.Join(headTags, []byte("\n"))
+	return bytes.Join(headTags, []byte("\n")), nil
 }
 
-func loadModuleTagged(tag, filename, encoding string) ([]byte, error) {
-	source, err := fileReadAllWithEncoding(filename, encoding)
+func loadModuleTagged(tag, filename, encoding string, rep reporter) ([]byte, error) {
+	source, err := fileReadAllWithEncoding(filename, encoding, rep)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,349 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	// external packages
+	"golang.org/x/text/unicode/norm"
+)
+
+// Info passages are passages which contain solely structural data, metadata,
+// and code, rather than any actual story content.
+var infoPassages = []string{
+	// Story formats: Twine 1.4+ vanilla & SugarCube.
+	"StoryAuthor", "StoryInit", "StoryMenu", "StorySubtitle", "StoryTitle",
+
+	// Story formats: SugarCube.
+	"PassageReady", "PassageDone", "PassageHeader", "PassageFooter", "StoryBanner", "StoryCaption",
+
+	// Story formats: SugarCube (v1 only).
+	"MenuOptions", "MenuShare", "MenuStory",
+
+	// Story formats: SugarCube (v2 only).
+	"StoryInterface", "StoryShare",
+
+	// Story formats: Twine 1.4+ vanilla.
+	// Compilers: Twine/Twee 1.4+, Twee2, & Tweego.
+	"StorySettings",
+
+	// Compilers: Tweego & (whatever Dan Cox's compiler is called).
+	"StoryData",
+
+	// Compilers: Twine/Twee 1.4+ & Twee2.
+	"StoryIncludes",
+}
+
+type passageMetadata struct {
+	position string                     // Unused by Tweego.  Twine 1 & 2 passage block X and Y coordinates CSV.
+	size     string                     // Unused by Tweego.  Twine 2 passage block width and height CSV.
+	extra    map[string]json.RawMessage // Unused by Tweego.  Other Twee 3 metadata properties—e.g., those of other tools—kept as-is.
+}
+
+// passageOrigin is the provenance of a passage—i.e., where it was loaded from.
+//
+// NOTE: Lines and offsets are relative to the decoded source of the file—i.e.,
+// after charset conversion, BOM removal, and record separator normalization.
+type passageOrigin struct {
+	filename string // Name of the file the passage was loaded from.
+	line     int    // Line within the file (1-base) of the passage header; 0 if unknown.
+	textLine int    // Line within the file (1-base) of the passage text; 0 if unknown.
+	textCol  int    // Characters preceding the passage text on its first line—e.g., trimmed whitespace.
+	offset   int    // Starting position within the file, in bytes, of the passage header.
+}
+
+// position returns the position of the passage header as `filename:line`,
+// or `filename` if the line is unknown, or an empty string if the origin
+// itself is unknown.
+func (o passageOrigin) position() string {
+	if o.filename == "" {
+		return ""
+	}
+	if o.line == 0 {
+		return o.filename
+	}
+	return fmt.Sprintf("%s:%d", o.filename, o.line)
+}
+
+// textPosition returns the position of the given line (0-base) within the
+// passage text as `filename:line`.  If the text line is unknown, it falls
+// back to the position of the passage header.
+func (o passageOrigin) textPosition(line int) string {
+	if o.textLine == 0 {
+		return o.position()
+	}
+	return fmt.Sprintf("%s:%d", o.filename, o.textLine+line)
+}
+
+// Passage is a passage of a story.
+type Passage struct {
+	// Core.
+	name string
+	tags []string
+	text string
+
+	// Compiler metadata.
+	metadata *passageMetadata
+
+	// Tweego compiler internals.
+	origin passageOrigin
+}
+
+func newPassage(name string, tags []string, source string) *Passage {
+	return &Passage{
+		name: name,
+		tags: tags,
+		text: source,
+	}
+}
+
+// Name returns the name of the passage.
+func (p *Passage) Name() string {
+	return p.name
+}
+
+// Tags returns the tags of the passage.
+func (p *Passage) Tags() []string {
+	return p.tags
+}
+
+// Text returns the text of the passage.
+func (p *Passage) Text() string {
+	return p.text
+}
+
+// Filename returns the name of the source file of the passage, or an empty
+// string if unknown.
+func (p *Passage) Filename() string {
+	return p.origin.filename
+}
+
+// Line returns the line within the source file (1-base) of the passage header,
+// or 0 if unknown.
+func (p *Passage) Line() int {
+	return p.origin.line
+}
+
+// Position returns the position of the passage header within its source file
+// as `filename:line`, or `filename` if the line is unknown, or an empty string
+// if the source file itself is unknown.
+func (p *Passage) Position() string {
+	return p.origin.position()
+}
+
+func (p *Passage) equals(second Passage) bool {
+	return p.text == second.text
+}
+
+func (p *Passage) tagsHas(needle string) bool {
+	if len(p.tags) > 0 {
+		for _, tag := range p.tags {
+			if tag == needle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Passage) tagsHasAny(needles ...string) bool {
+	if len(p.tags) > 0 {
+		for _, tag := range p.tags {
+			for _, needle := range needles {
+				if tag == needle {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (p *Passage) tagsContains(needle string) bool {
+	if len(p.tags) > 0 {
+		for _, tag := range p.tags {
+			if strings.Contains(tag, needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Passage) tagsStartsWith(needle string) bool {
+	if len(p.tags) > 0 {
+		for _, tag := range p.tags {
+			if strings.HasPrefix(tag, needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Passage) hasMetadataPosition() bool {
+	return p.metadata != nil && p.metadata.position != ""
+}
+
+func (p *Passage) hasMetadataSize() bool {
+	return p.metadata != nil && p.metadata.size != ""
+}
+
+func (p *Passage) hasAnyMetadata() bool {
+	return p.metadata != nil && (p.metadata.position != "" || p.metadata.size != "" || len(p.metadata.extra) > 0)
+}
+
+func (p *Passage) hasInfoTags() bool {
+	return p.tagsHasAny("annotation", "script", "stylesheet", "widget") || p.tagsStartsWith("Twine.")
+}
+
+func (p *Passage) hasInfoName() bool {
+	return stringSliceContains(infoPassages, p.name)
+}
+
+func (p *Passage) isInfoPassage() bool {
+	return p.hasInfoName() || p.hasInfoTags()
+}
+
+// IsStoryPassage reports whether the passage is a story passage—i.e., neither
+// its name nor its tags mark it as an info passage.
+func (p *Passage) IsStoryPassage() bool {
+	return !p.hasInfoName() && !p.hasInfoTags()
+}
+
+func (p *Passage) toTwee(outMode OutputMode) string {
+	var output string
+	if outMode == OutModeTwee3 {
+		output = ":: " + tweeEscapeString(p.name)
+		if len(p.tags) > 0 {
+			output += " [" + tweeEscapeString(strings.Join(p.tags, " ")) + "]"
+		}
+		if p.hasAnyMetadata() {
+			output += " " + string(p.marshalMetadata())
+		}
+	} else {
+		output = ":: " + p.name
+		if len(p.tags) > 0 {
+			output += " [" + strings.Join(p.tags, " ") + "]"
+		}
+	}
+	output += "\n"
+	if len(p.text) > 0 {
+		output += p.text + "\n"
+	}
+	output += "\n\n"
+	return output
+}
+
+// twine2Position returns the Twine 2 passage block position of the passage.
+func (p *Passage) twine2Position(pid uint) string {
+	if p.hasMetadataPosition() {
+		return p.metadata.position
+	}
+
+	// No position metadata, so generate something sensible on the fly.
+	x := pid % 10
+	y := pid / 10
+	if x == 0 {
+		x = 10
+	} else {
+		y++
+	}
+	return fmt.Sprintf("%d,%d", x*125-25, y*125-25)
+}
+
+// twine2Size returns the Twine 2 passage block size of the passage.
+func (p *Passage) twine2Size() string {
+	if p.hasMetadataSize() {
+		return p.metadata.size
+	}
+
+	// No size metadata, so default to the normal size.
+	return "100,100"
+}
+
+func (p *Passage) toPassagedata(pid uint) string {
+	var (
+		position = p.twine2Position(pid)
+		size     = p.twine2Size()
+	)
+
+	/*
+		<tw-passagedata pid="…" name="…" tags="…" position="…" size="…">…</tw-passagedata>
+	*/
+	return fmt.Sprintf(`<tw-passagedata pid="%d" name=%q tags=%q position=%q size=%q>%s</tw-passagedata>`,
+		pid,
+		attrEscapeString(p.name),
+		attrEscapeString(strings.Join(p.tags, " ")),
+		attrEscapeString(position),
+		attrEscapeString(size),
+		htmlEscapeString(p.text),
+	)
+}
+
+func (p *Passage) toTiddler(pid uint) string {
+	var position string
+	if p.hasMetadataPosition() {
+		position = p.metadata.position
+	} else {
+		// No position metadata, so generate something sensible on the fly.
+		x := pid % 10
+		y := pid / 10
+		if x == 0 {
+			x = 10
+		} else {
+			y++
+		}
+		position = fmt.Sprintf("%d,%d", x*140-130, y*140-130)
+	}
+
+	/*
+		<div tiddler="…" tags="…" created="…" modifier="…" twine-position="…">…</div>
+	*/
+	return fmt.Sprintf(`<div tiddler=%q tags=%q twine-position=%q>%s</div>`,
+		attrEscapeString(p.name),
+		attrEscapeString(strings.Join(p.tags, " ")),
+		attrEscapeString(position),
+		tiddlerEscapeString(p.text),
+	)
+}
+
+// CountWords returns the count of "words" within the passage text, typing
+// measurement style—i.e., 5 "characters" per "word".
+func (p *Passage) CountWords() uint64 {
+	text := p.text
+
+	// Strip newlines.
+	text = strings.Replace(text, "\n", "", -1)
+
+	// Strip comments.
+	re := regexp.MustCompile(`(?s:/%.*?%/|/\*.*?\*/|<!--.*?-->)`)
+	text = re.ReplaceAllString(text, "")
+
+	// Count normalized "characters".
+	var (
+		count uint64
+		ia    norm.Iter
+	)
+	ia.InitString(norm.NFKD, text)
+	for !ia.Done() {
+		count++
+		ia.Next()
+	}
+
+	// Count "words", typing measurement style—i.e., 5 "characters" per "word".
+	words := count / 5
+	if count%5 > 0 {
+		words++
+	}
+
+	return words
+}
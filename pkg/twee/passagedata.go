@@ -4,7 +4,7 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	"encoding/json"
@@ -15,11 +15,19 @@ type passageMetadataJSON struct {
 	Size     string `json:"size,omitempty"`     // Twine 2 (`size`).
 }
 
-func (p *passage) marshalMetadata() []byte {
-	marshaled, err := json.Marshal(&passageMetadataJSON{
-		p.metadata.position,
-		p.metadata.size,
-	})
+func (p *Passage) marshalMetadata() []byte {
+	// NOTE: Marshaling a map sorts its keys, so the output is deterministic.
+	metadata := make(map[string]interface{}, len(p.metadata.extra)+2)
+	for key, val := range p.metadata.extra {
+		metadata[key] = val
+	}
+	if p.metadata.position != "" {
+		metadata["position"] = p.metadata.position
+	}
+	if p.metadata.size != "" {
+		metadata["size"] = p.metadata.size
+	}
+	marshaled, err := json.Marshal(metadata)
 	if err != nil {
 		// NOTE: We should never be able to see an error here.  If we do,
 		// then something truly exceptional—in a bad way—has happened, so
@@ -29,14 +37,24 @@ func (p *passage) marshalMetadata() []byte {
 	return marshaled
 }
 
-func (p *passage) unmarshalMetadata(marshaled []byte) error {
+func (p *Passage) unmarshalMetadata(marshaled []byte) error {
 	metadata := passageMetadataJSON{}
 	if err := json.Unmarshal(marshaled, &metadata); err != nil {
 		return err
 	}
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(marshaled, &extra); err != nil {
+		return err
+	}
+	delete(extra, "position")
+	delete(extra, "size")
+	if len(extra) == 0 {
+		extra = nil
+	}
 	p.metadata = &passageMetadata{
 		position: metadata.Position,
 		size:     metadata.Size,
+		extra:    extra,
 	}
 	return nil
 }
@@ -0,0 +1,245 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Twine 1 story metadata.
+type twine1Metadata struct {
+	// WARNING: Do not use individual fields here as story formats are allowed to
+	// define their own `StorySettings` pairs—via a custom header file—so we have
+	// no way of knowing what the keys might be prior to parsing the passage.
+	settings map[string]string // Map of `StorySettings` key/value pairs.
+}
+
+// Twine 2 story metadata.
+type twine2OptionsMap map[string]bool
+type twine2TagColorsMap map[string]string
+type twine2Metadata struct {
+	creator        string             // Name of the program which created the story, if not Tweego.
+	creatorVersion string             // Version of the program which created the story.
+	format         string             // Name of the story format.
+	formatVersion  string             // SemVer of the story format.
+	options        twine2OptionsMap   // Map of option-name/bool pairs.
+	start          string             // Name of the starting passage.
+	tags           []string           // Unused by Tweego.  Tags of the story itself, rather than of its passages.
+	tagColors      twine2TagColorsMap // Unused by Tweego.  Map of tag-name/color pairs.
+	zoom           float64            // Unused by Tweego.  Zoom level.  Why is this even a part of the story metadata?  It's editor configuration.
+}
+
+// Story is a story, loaded from its sources.
+type Story struct {
+	name     string
+	ifid     string // A v4 random UUID, see: https://ifdb.tads.org/help-ifid.
+	passages []*Passage
+
+	// Legacy fields from Tweego v1 StorySettings.
+	legacyIFID string
+
+	// Twine 1 & 2 compiler metadata.
+	twine1 twine1Metadata
+	twine2 twine2Metadata
+
+	// Tweego compiler internals.
+	excludeTags   []string // Tags whose passages are excluded from the story.
+	format        *Format
+	files         []string // Names of the source files loaded, in order.
+	externalFiles []string // Names of the external files—i.e., modules and the head file—compiled, in order.
+	processed     map[string]bool
+	recorded      []*Passage // Passages added, as they were prior to being added, while recording for the build cache.
+	reporter      reporter
+}
+
+// newStory creates a new story instance.
+func newStory() *Story {
+	return &Story{
+		passages: make([]*Passage, 0, 64), // Initially create enough space for 64 passages.
+		twine1: twine1Metadata{
+			settings: make(map[string]string),
+		},
+		twine2: twine2Metadata{
+			options:   make(twine2OptionsMap),
+			tagColors: make(twine2TagColorsMap),
+			zoom:      1,
+		},
+		processed: make(map[string]bool),
+	}
+}
+
+// Name returns the name of the story.
+func (s *Story) Name() string {
+	return s.name
+}
+
+// IFID returns the IFID of the story.
+func (s *Story) IFID() string {
+	return s.ifid
+}
+
+// Passages returns the passages of the story, in order.
+func (s *Story) Passages() []*Passage {
+	return s.passages
+}
+
+// Files returns the names of the source files loaded, in order.
+func (s *Story) Files() []string {
+	return s.files
+}
+
+// ExternalFiles returns the names of the external files—i.e., modules and the
+// head file—added to the output by the last compile, in order.
+func (s *Story) ExternalFiles() []string {
+	return s.externalFiles
+}
+
+// Format returns the story format selected by the last compile, if any.
+func (s *Story) Format() *Format {
+	return s.format
+}
+
+// report reports the diagnostic, see Options.Report.
+func (s *Story) report(d *Diagnostic) {
+	s.reporter.report(d)
+}
+
+func (s *Story) count() int {
+	return len(s.passages)
+}
+
+func (s *Story) has(name string) bool {
+	for _, p := range s.passages {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Story) index(name string) int {
+	for i, p := range s.passages {
+		if p.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Story) get(name string) (*Passage, error) {
+	for _, p := range s.passages {
+		if p.name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("get %s: No such passage.", name)
+}
+
+func (s *Story) deleteAt(i int) error {
+	upper := len(s.passages) - 1
+	if 0 > i || i > upper {
+		return fmt.Errorf("deleteAt %d: Index out of range.", i)
+	}
+
+	// TODO: Should the `copy()` only occur if `i < upper`?
+	copy(s.passages[i:], s.passages[i+1:]) // shift elements down by one to overwrite the original element
+
+	s.passages[upper] = nil         // zero the last element, which was itself duplicated by the last operation
+	s.passages = s.passages[:upper] // reslice to remove the last element
+	return nil
+}
+
+func (s *Story) append(p *Passage) {
+	// Append the passage if new, elsewise replace the existing version.
+	if i := s.index(p.name); i == -1 {
+		s.passages = append(s.passages, p)
+	} else {
+		s.replaceAt(i, p)
+	}
+}
+
+func (s *Story) prepend(p *Passage) {
+	// Prepend the passage if new, elsewise replace the existing version.
+	if i := s.index(p.name); i == -1 {
+		s.passages = append([]*Passage{p}, s.passages...)
+	} else {
+		s.replaceAt(i, p)
+	}
+}
+
+func (s *Story) replaceAt(i int, p *Passage) {
+	if pos := s.passages[i].origin.position(); pos != "" {
+		s.report(newPassageDiagnostic(SeverityWarning, "duplicate-passage", p, "Replacing existing passage %q (from %s) with duplicate.", p.name, pos))
+	} else {
+		s.report(newPassageDiagnostic(SeverityWarning, "duplicate-passage", p, "Replacing existing passage %q with duplicate.", p.name))
+	}
+	s.passages[i] = p
+}
+
+func (s *Story) add(p *Passage) error {
+	// Record a copy of the passage, as the following may modify it.
+	if s.recorded != nil {
+		cp := *p
+		s.recorded = append(s.recorded, &cp)
+	}
+
+	// Drop passages with excluded tags.
+	if len(s.excludeTags) > 0 && p.tagsHasAny(s.excludeTags...) {
+		return nil
+	}
+
+	// Preprocess compiler-oriented special passages.
+	switch p.name {
+	case "StoryIncludes":
+		/*
+			NOTE: StoryIncludes is a compiler special passage for Twine 1.4,
+			and apparently Twee2.  Twee 1.4 does not support it—likely for
+			the same reasons Tweego will not (outlined below).
+
+			You may specify an arbitrary number of files and directories on
+			the the command line for Tweego to process.  Furthermore, it will
+			search all directories encountered during processing looking for
+			additional files and directories.  Thus, supporting StoryIncludes
+			would be beyond pointless.
+
+			If we see StoryIncludes, log a warning.
+		*/
+		s.report(newPassageDiagnostic(SeverityWarning, "story-includes", p,
+			`Ignoring "StoryIncludes" compiler special passage; and it is `+
+				`recommended that you remove it.  Tweego allows you to specify project `+
+				`files and/or directories to recursively search for such files on the `+
+				`command line.  Thus, in practice, you only need to specify a project's `+
+				`root directory and Tweego will find all of its files automatically.`))
+	case "StoryData":
+		if err := s.unmarshalStoryData([]byte(p.text)); err == nil {
+			// Validiate the IFID.
+			if len(s.ifid) > 0 {
+				if err := validateIFID(s.ifid); err != nil {
+					return newPassageDiagnostic(SeverityError, "invalid-ifid", p, `Cannot validate IFID; %s.`, err.Error())
+				}
+			}
+
+			// Rebuild the passage contents to remove deleted and/or erroneous entries.
+			p.text = string(s.marshalStoryData())
+		} else {
+			// log.Printf(`warning: Cannot unmarshal "StoryData" compiler special passage; %s.`, err.Error())
+			return newPassageDiagnostic(SeverityError, "story-data", p, `Cannot unmarshal "StoryData" compiler special passage; %s.`, err.Error())
+		}
+	case "StorySettings":
+		if err := s.unmarshalStorySettings(p); err != nil {
+			s.report(newPassageDiagnostic(SeverityWarning, "story-settings", p, `Cannot unmarshal "StorySettings" special passage; %s.`, err.Error()))
+		}
+	case "StoryTitle":
+		// Rebuild the passage contents to trim erroneous whitespace surrounding the title.
+		p.text = strings.TrimSpace(p.text)
+		s.name = p.text
+	}
+
+	s.append(p)
+	return nil
+}
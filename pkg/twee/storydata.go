@@ -4,33 +4,39 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	"bytes"
 	"encoding/json"
-	"log"
+	"sort"
 	"strings"
 )
 
 type storyDataJSON struct {
-	Ifid          string             `json:"ifid,omitempty"`
-	Format        string             `json:"format,omitempty"`
-	FormatVersion string             `json:"format-version,omitempty"`
-	Options       []string           `json:"options,omitempty"`
-	Start         string             `json:"start,omitempty"`
-	TagColors     twine2TagColorsMap `json:"tag-colors,omitempty"`
-	Zoom          float64            `json:"zoom,omitempty"`
+	Ifid           string             `json:"ifid,omitempty"`
+	Creator        string             `json:"creator,omitempty"`
+	CreatorVersion string             `json:"creator-version,omitempty"`
+	Format         string             `json:"format,omitempty"`
+	FormatVersion  string             `json:"format-version,omitempty"`
+	Options        []string           `json:"options,omitempty"`
+	Start          string             `json:"start,omitempty"`
+	Tags           []string           `json:"tags,omitempty"`
+	TagColors      twine2TagColorsMap `json:"tag-colors,omitempty"`
+	Zoom           float64            `json:"zoom,omitempty"`
 }
 
-func (s *story) marshalStoryData() []byte {
+func (s *Story) marshalStoryData() []byte {
 	marshaled, err := json.MarshalIndent(
 		&storyDataJSON{
 			s.ifid,
+			s.twine2.creator,
+			s.twine2.creatorVersion,
 			s.twine2.format,
 			s.twine2.formatVersion,
 			twine2OptionsMapToSlice(s.twine2.options),
 			s.twine2.start,
+			s.twine2.tags,
 			s.twine2.tagColors,
 			s.twine2.zoom,
 		},
@@ -46,16 +52,24 @@ func (s *story) marshalStoryData() []byte {
 	return marshaled
 }
 
-func (s *story) unmarshalStoryData(marshaled []byte) error {
+func (s *Story) unmarshalStoryData(marshaled []byte) error {
 	storyData := storyDataJSON{}
 	if err := json.Unmarshal(marshaled, &storyData); err != nil {
 		return err
 	}
 	s.ifid = strings.ToUpper(storyData.Ifid) // NOTE: Force uppercase for consistency.
+	s.twine2.creator = storyData.Creator
+	s.twine2.creatorVersion = storyData.CreatorVersion
 	s.twine2.format = storyData.Format
 	s.twine2.formatVersion = storyData.FormatVersion
 	s.twine2.options = twine2OptionsSliceToMap(storyData.Options)
 	s.twine2.start = storyData.Start
+	s.twine2.tags = storyData.Tags
+	// NOTE: Keep the map of tag colors non-nil, as later sources—e.g., Twine 2
+	// HTML or JSON—add their tag colors to it.
+	if storyData.TagColors == nil {
+		storyData.TagColors = make(twine2TagColorsMap)
+	}
 	s.twine2.tagColors = storyData.TagColors
 	if storyData.Zoom != 0 {
 		s.twine2.zoom = storyData.Zoom
@@ -71,6 +85,8 @@ func twine2OptionsMapToSlice(optMap twine2OptionsMap) []string {
 				optSlice = append(optSlice, opt)
 			}
 		}
+		// NOTE: Sort the options, so that the output is deterministic.
+		sort.Strings(optSlice)
 	}
 	return optSlice
 }
@@ -91,7 +107,7 @@ func twine2OptionsSliceToMap(optSlice []string) twine2OptionsMap {
 // 	return bytes.Join(marshaled, []byte("\n"))
 // }
 
-func (s *story) unmarshalStorySettings(marshaled []byte) error {
+func (s *Story) unmarshalStorySettings(p *Passage) error {
 	/*
 		NOTE: (ca. Feb 28, 2019) Transition away from storing metadata within
 		the StorySettings special passage and to the StoryData special passages
@@ -111,7 +127,7 @@ func (s *story) unmarshalStorySettings(marshaled []byte) error {
 	/*
 		END LEGACY
 	*/
-	for _, line := range bytes.Split(marshaled, []byte{'\n'}) {
+	for n, line := range bytes.Split([]byte(p.text), []byte{'\n'}) {
 		line = bytes.TrimSpace(line)
 		if len(line) > 0 {
 			if i := bytes.IndexRune(line, ':'); i != -1 {
@@ -139,7 +155,11 @@ func (s *story) unmarshalStorySettings(marshaled []byte) error {
 
 				s.twine1.settings[key] = val
 			} else {
-				log.Printf(`warning: Malformed "StorySettings" entry; skipping %q.`, line)
+				d := newPassageDiagnostic(SeverityWarning, "story-settings", p, `Malformed "StorySettings" entry; skipping %q.`, line)
+				if p.origin.textLine != 0 {
+					d.Line = p.origin.textLine + n
+				}
+				s.report(d)
 			}
 		}
 	}
@@ -158,14 +178,14 @@ func (s *story) unmarshalStorySettings(marshaled []byte) error {
 			entries = "entries"
 			pronoun = "them"
 		}
-		log.Printf(
-			`warning: Detected obsolete "StorySettings" %s: %s.  `+
+		s.report(newPassageDiagnostic(SeverityWarning, "story-settings", p,
+			`Detected obsolete "StorySettings" %s: %s.  `+
 				`Please remove %s from the "StorySettings" special passage.  If doing `+
 				`so leaves the passage empty, please remove it as well.`,
 			entries,
 			strings.Join(obsolete, ", "),
 			pronoun,
-		)
+		))
 	}
 	/*
 		END LEGACY
@@ -0,0 +1,225 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// storyGraphNode is a passage within the story link graph.
+type storyGraphNode struct {
+	passage *Passage
+	links   []string // Names of the existing passages linked to, in order.
+	broken  []string // Names of the nonexistent passages linked to, in order.
+}
+
+// getGraph returns the nodes of the story link graph.  The node set is the
+// same as the normal passages within the Twine 2 story data chunk.
+func (s *Story) getGraph() []*storyGraphNode {
+	var (
+		passages = s.getTwine2Passages()
+		names    = make(map[string]bool, len(passages))
+		nodes    = make([]*storyGraphNode, 0, len(passages))
+	)
+	for _, p := range passages {
+		names[p.name] = true
+	}
+	for _, p := range passages {
+		var (
+			node = &storyGraphNode{passage: p}
+			seen = make(map[string]bool)
+		)
+		for _, link := range p.links() {
+			if seen[link.target] {
+				continue
+			}
+			seen[link.target] = true
+
+			if names[link.target] {
+				node.links = append(node.links, link.target)
+			} else {
+				node.broken = append(node.broken, link.target)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Reachability returns the story passages which cannot be reached from the
+// starting passage (unreachable) and those which have no outgoing links (dead
+// ends), in order.  Passages tagged with any of the exempt tags are excluded.
+//
+// NOTE: Passages with info names—e.g., `StoryMenu` or `PassageHeader`—are
+// displayed by story formats without being linked to, so links within them
+// are also treated as reachable.
+func (s *Story) Reachability(startName string, exemptTags []string) (unreachable, deadEnds []*Passage) {
+	var (
+		nodes   = s.getGraph()
+		byName  = make(map[string]*storyGraphNode, len(nodes))
+		reached = make(map[string]bool, len(nodes))
+		queue   []string
+	)
+	for _, node := range nodes {
+		byName[node.passage.name] = node
+		if node.passage.name == startName || node.passage.hasInfoName() {
+			reached[node.passage.name] = true
+			queue = append(queue, node.passage.name)
+		}
+	}
+	for len(queue) > 0 {
+		node := byName[queue[0]]
+		queue = queue[1:]
+		for _, target := range node.links {
+			if !reached[target] {
+				reached[target] = true
+				queue = append(queue, target)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		p := node.passage
+		if !p.IsStoryPassage() || p.tagsHasAny(exemptTags...) {
+			continue
+		}
+		if !reached[p.name] {
+			unreachable = append(unreachable, p)
+		}
+		if len(node.links) == 0 && len(node.broken) == 0 {
+			deadEnds = append(deadEnds, p)
+		}
+	}
+	return unreachable, deadEnds
+}
+
+// Escapes the characters which are special within DOT quoted strings.
+var dotEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+func (s *Story) toGraphDOT(startName string) []byte {
+	var (
+		nodes   = s.getGraph()
+		data    []byte
+		missing []string
+		seen    = make(map[string]bool)
+	)
+
+	data = append(data, fmt.Sprintf("digraph %s {\n", dotQuote(s.name))...)
+	data = append(data, "\tnode [shape=box];\n"...)
+
+	// Nodes.
+	for _, node := range nodes {
+		p := node.passage
+		label := p.name
+		if len(p.tags) > 0 {
+			label += "\n[" + strings.Join(p.tags, " ") + "]"
+		}
+		attrs := []string{"label=" + dotQuote(label)}
+		if p.name == startName {
+			attrs = append(attrs, "peripheries=2", "style=bold")
+		}
+		data = append(data, fmt.Sprintf("\t%s [%s];\n", dotQuote(p.name), strings.Join(attrs, ", "))...)
+
+		for _, target := range node.broken {
+			if !seen[target] {
+				seen[target] = true
+				missing = append(missing, target)
+			}
+		}
+	}
+	for _, target := range missing {
+		data = append(data, fmt.Sprintf("\t%s [style=dashed, color=red];\n", dotQuote(target))...)
+	}
+
+	// Edges.
+	for _, node := range nodes {
+		for _, target := range node.links {
+			data = append(data, fmt.Sprintf("\t%s -> %s;\n", dotQuote(node.passage.name), dotQuote(target))...)
+		}
+		for _, target := range node.broken {
+			data = append(data, fmt.Sprintf("\t%s -> %s [style=dashed, color=red];\n", dotQuote(node.passage.name), dotQuote(target))...)
+		}
+	}
+
+	data = append(data, "}\n"...)
+	return data
+}
+
+type storyGraphJSON struct {
+	Name        string                  `json:"name"`
+	Ifid        string                  `json:"ifid,omitempty"`
+	Start       string                  `json:"start"`
+	Passages    []storyGraphPassageJSON `json:"passages"`
+	Unreachable []string                `json:"unreachable"`
+	DeadEnds    []string                `json:"dead-ends"`
+}
+
+type storyGraphPassageJSON struct {
+	Name   string   `json:"name"`
+	Tags   []string `json:"tags"`
+	Start  bool     `json:"start,omitempty"`
+	Links  []string `json:"links"`
+	Broken []string `json:"broken,omitempty"`
+}
+
+func (s *Story) toGraphJSON(startName string, exemptTags []string) []byte {
+	var (
+		nodes                 = s.getGraph()
+		unreachable, deadEnds = s.Reachability(startName, exemptTags)
+		graph                 = storyGraphJSON{
+			Name:        s.name,
+			Ifid:        s.ifid,
+			Start:       startName,
+			Passages:    make([]storyGraphPassageJSON, 0, len(nodes)),
+			Unreachable: make([]string, 0, len(unreachable)),
+			DeadEnds:    make([]string, 0, len(deadEnds)),
+		}
+	)
+	for _, p := range unreachable {
+		graph.Unreachable = append(graph.Unreachable, p.name)
+	}
+	for _, p := range deadEnds {
+		graph.DeadEnds = append(graph.DeadEnds, p.name)
+	}
+	for _, node := range nodes {
+		p := node.passage
+		entry := storyGraphPassageJSON{
+			Name:   p.name,
+			Tags:   p.tags,
+			Start:  p.name == startName,
+			Links:  node.links,
+			Broken: node.broken,
+		}
+		// Ensure empty lists are encoded as such, rather than as null.
+		if entry.Tags == nil {
+			entry.Tags = []string{}
+		}
+		if entry.Links == nil {
+			entry.Links = []string{}
+		}
+		graph.Passages = append(graph.Passages, entry)
+	}
+
+	marshaled, err := json.MarshalIndent(&graph, "", "\t")
+	if err != nil {
+		// NOTE: We should never be able to see an error here.  If we do,
+		// then something truly exceptional—in a bad way—has happened, so
+		// we get our panic on.
+		panic(err)
+	}
+	return append(marshaled, '\n')
+}
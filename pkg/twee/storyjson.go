@@ -0,0 +1,256 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	Twine 2 JSON—i.e., the story data chunk as JSON, in the style of Twison
+	(https://github.com/lazerwalker/twison), extended with the remainder of
+	the story metadata and passage sizes.
+*/
+
+type twine2StoryJSON struct {
+	Name           string              `json:"name"`
+	StartNode      twine2JSONString    `json:"startnode,omitempty"`
+	Creator        string              `json:"creator,omitempty"`
+	CreatorVersion string              `json:"creator-version,omitempty"`
+	IFID           string              `json:"ifid"`
+	Format         string              `json:"format,omitempty"`
+	FormatVersion  string              `json:"format-version,omitempty"`
+	Options        []string            `json:"options,omitempty"`
+	Tags           []string            `json:"tags,omitempty"`
+	TagColors      twine2TagColorsMap  `json:"tag-colors,omitempty"`
+	Zoom           float64             `json:"zoom,omitempty"`
+	Passages       []twine2PassageJSON `json:"passages"`
+}
+
+type twine2PassageJSON struct {
+	Text     string              `json:"text"`
+	Links    []twine2LinkJSON    `json:"links,omitempty"`
+	Name     string              `json:"name"`
+	PID      twine2JSONString    `json:"pid"`
+	Position *twine2PositionJSON `json:"position,omitempty"`
+	Size     *twine2SizeJSON     `json:"size,omitempty"`
+	Tags     []string            `json:"tags,omitempty"`
+}
+
+type twine2LinkJSON struct {
+	Name string           `json:"name"` // Text of the link.
+	Link string           `json:"link"` // Name of the linked passage.
+	PID  twine2JSONString `json:"pid,omitempty"`
+}
+
+type twine2PositionJSON struct {
+	X twine2JSONString `json:"x"`
+	Y twine2JSONString `json:"y"`
+}
+
+type twine2SizeJSON struct {
+	Width  twine2JSONString `json:"width"`
+	Height twine2JSONString `json:"height"`
+}
+
+// twine2JSONString is a string which, when unmarshaling, may also be a number.
+//
+// NOTE: Twison marshals PIDs and positions as strings, as they're content
+// attributes within the story data chunk, however, other tools may not.
+type twine2JSONString string
+
+func (js *twine2JSONString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*js = twine2JSONString(n.String())
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*js = twine2JSONString(str)
+	return nil
+}
+
+func (s *Story) toTwine2JSON(startName string, opts *Options) ([]byte, error) {
+	// Check the IFID status.
+	if err := s.checkIFID(); err != nil {
+		return nil, err
+	}
+
+	// NOTE: Number the passages as does the Twine 2 story data chunk, so that
+	// the PIDs agree, then number the remaining passages—e.g., scripts and
+	// stylesheets—after them.
+	var (
+		passages = s.getTwine2JSONPassages()
+		pids     = make(map[string]uint, len(passages))
+		startID  string
+	)
+	for i, p := range s.getTwine2Passages() {
+		pid := uint(i + 1)
+		pids[p.name] = pid
+		if startName == p.name {
+			startID = fmt.Sprint(pid)
+		}
+	}
+	for _, p := range passages {
+		if _, ok := pids[p.name]; !ok {
+			pids[p.name] = uint(len(pids) + 1)
+		}
+	}
+
+	creator, creatorVersion := s.twine2Creator(opts)
+	story := twine2StoryJSON{
+		Name:           s.name,
+		StartNode:      twine2JSONString(startID),
+		Creator:        creator,
+		CreatorVersion: creatorVersion,
+		IFID:           s.ifid,
+		Format:         s.format.name,
+		FormatVersion:  s.format.version,
+		Options:        twine2OptionsMapToSlice(s.twine2.options),
+		Tags:           s.twine2.tags,
+		TagColors:      s.twine2.tagColors,
+		Zoom:           s.twine2.zoom,
+		Passages:       make([]twine2PassageJSON, 0, len(passages)),
+	}
+	for _, p := range passages {
+		pid := pids[p.name]
+		passage := twine2PassageJSON{
+			Text: p.text,
+			Name: p.name,
+			PID:  twine2JSONString(fmt.Sprint(pid)),
+			Tags: p.tags,
+		}
+		if x, y, ok := splitPair(p.twine2Position(pid)); ok {
+			passage.Position = &twine2PositionJSON{twine2JSONString(x), twine2JSONString(y)}
+		}
+		if width, height, ok := splitPair(p.twine2Size()); ok {
+			passage.Size = &twine2SizeJSON{twine2JSONString(width), twine2JSONString(height)}
+		}
+		for _, link := range p.links() {
+			jsonLink := twine2LinkJSON{Name: link.text, Link: link.target}
+			if pid, ok := pids[link.target]; ok {
+				jsonLink.PID = twine2JSONString(fmt.Sprint(pid))
+			}
+			passage.Links = append(passage.Links, jsonLink)
+		}
+		story.Passages = append(story.Passages, passage)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&story); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getTwine2JSONPassages returns the passages of the Twine 2 JSON.  Unlike the
+// Twine 2 story data chunk, script and stylesheet passages are included as-is.
+func (s *Story) getTwine2JSONPassages() []*Passage {
+	var passages []*Passage
+	for _, p := range s.passages {
+		if p.name == "StoryTitle" || p.name == "StoryData" || p.tagsHas("Twine.private") {
+			continue
+		}
+		passages = append(passages, p)
+	}
+	return passages
+}
+
+// loadJSON loads the Twine 2 JSON file.
+func (s *Story) loadJSON(filename, encoding, creator string) error {
+	source, err := fileReadAllWithEncoding(filename, encoding, s.reporter)
+	if err != nil {
+		return err
+	}
+
+	var story twine2StoryJSON
+	if err := json.Unmarshal(source, &story); err != nil {
+		return fmt.Errorf("Malformed Twine 2 JSON source; %s.", err.Error())
+	}
+	if story.Passages == nil {
+		return fmt.Errorf("Malformed Twine 2 JSON source; no passages.")
+	}
+
+	s.name = story.Name
+	s.ifid = strings.ToUpper(story.IFID) // Force uppercase for consistency.
+	// NOTE: As with HTML sources, only creators other than the compiling
+	// program are kept, see `(*Story).loadHTML()`.
+	if !strings.EqualFold(story.Creator, creator) {
+		s.twine2.creator = story.Creator
+		s.twine2.creatorVersion = story.CreatorVersion
+	}
+	s.twine2.format = story.Format
+	s.twine2.formatVersion = story.FormatVersion
+	for _, opt := range story.Options {
+		s.twine2.options[opt] = true
+	}
+	s.twine2.tags = story.Tags
+	for tag, color := range story.TagColors {
+		s.twine2.tagColors[tag] = color
+	}
+	if story.Zoom != 0 {
+		s.twine2.zoom = story.Zoom
+	}
+
+	for _, passage := range story.Passages {
+		if passage.Name == "" {
+			return fmt.Errorf("Malformed Twine 2 JSON source; passage with no name.")
+		}
+		if passage.PID != "" && passage.PID == story.StartNode {
+			s.twine2.start = passage.Name
+		}
+
+		p := newPassage(passage.Name, passage.Tags, passage.Text)
+		p.metadata = &passageMetadata{}
+		if passage.Position != nil {
+			p.metadata.position = string(passage.Position.X) + "," + string(passage.Position.Y)
+		}
+		if passage.Size != nil {
+			p.metadata.size = string(passage.Size.Width) + "," + string(passage.Size.Height)
+		}
+		p.origin = passageOrigin{filename: filename}
+		if err := s.add(p); err != nil {
+			return err
+		}
+	}
+
+	// Prepend the `StoryData` special passage.  Includes the story IFID and Twine 2 metadata.
+	p := newPassage("StoryData", []string{}, string(s.marshalStoryData()))
+	p.origin.filename = filename
+	s.prepend(p)
+
+	return nil
+}
+
+// splitPair splits a CSV pair of numbers—e.g., a Twine 2 passage position.
+func splitPair(pair string) (string, string, bool) {
+	parts := strings.Split(pair, ",")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+		if _, err := strconv.ParseFloat(parts[i], 64); err != nil {
+			return "", "", false
+		}
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,592 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	// internal packages
+	twee2 "github.com/tmedwards/tweego/internal/twee2compat"
+	twlex "github.com/tmedwards/tweego/internal/tweelexer"
+	// external packages
+	"golang.org/x/net/html"
+)
+
+// load loads the given files.  If a build cache is given, the passages of files
+// unchanged since they were cached are reused, rather than loaded again.
+//
+// NOTE: HTML and JSON files are never cached, as they may also modify the story's
+// own metadata.
+func (s *Story) load(filenames []string, opts *Options) error {
+	cache := opts.Cache
+	for _, filename := range filenames {
+		if s.processed[filename] {
+			s.report(newFileDiagnostic(SeverityWarning, "duplicate-file", filename, "Skipping duplicate."))
+			continue
+		}
+
+		ext := sourceFileExt(filename)
+		cacheable := cache != nil && KnownFileType(filename) && ext != "htm" && ext != "html" && ext != "twine2.json"
+		var stamp fileStamp
+		if cacheable {
+			var (
+				passages []*Passage
+				ok       bool
+			)
+			if passages, stamp, ok = cache.lookup(filename); ok {
+				for _, p := range passages {
+					cp := *p
+					if err := s.add(&cp); err != nil {
+						return err
+					}
+				}
+				s.processed[filename] = true
+				s.files = append(s.files, filename)
+				continue
+			}
+			s.recorded = make([]*Passage, 0, 16)
+		}
+
+		var err error
+		switch ext {
+		// NOTE: The case values here should match those in `filesystem.go:KnownFileType()`.
+		case "tw", "twee":
+			err = s.loadTwee(filename, opts.Encoding, !opts.NoTrim, opts.Twee2Compat)
+		case "tw2", "twee2":
+			err = s.loadTwee(filename, opts.Encoding, !opts.NoTrim, true)
+		case "htm", "html":
+			err = s.loadHTML(filename, opts.Encoding, opts.Story, opts.creator())
+		case "twine2.json":
+			err = s.loadJSON(filename, opts.Encoding, opts.creator())
+		case "css":
+			err = s.loadTagged("stylesheet", filename, opts.Encoding)
+		case "js":
+			err = s.loadTagged("script", filename, opts.Encoding)
+		case "otf", "ttf", "woff", "woff2":
+			err = s.loadFont(filename)
+		case "gif", "jpeg", "jpg", "png", "svg", "tif", "tiff", "webp":
+			err = s.loadMedia("Twine.image", filename)
+		case "aac", "flac", "m4a", "mp3", "oga", "ogg", "opus", "wav", "wave", "weba":
+			err = s.loadMedia("Twine.audio", filename)
+		case "mp4", "ogv", "webm":
+			err = s.loadMedia("Twine.video", filename)
+		case "vtt":
+			err = s.loadMedia("Twine.vtt", filename)
+		default:
+			// Simply ignore all other file types.
+			continue
+		}
+		recorded := s.recorded
+		s.recorded = nil
+		if err != nil {
+			if _, ok := err.(*Diagnostic); ok {
+				return err
+			}
+			return newFileDiagnostic(SeverityError, "load", filename, "%s", err.Error())
+		}
+		if cacheable {
+			cache.store(filename, stamp, recorded)
+		}
+		s.processed[filename] = true
+		s.files = append(s.files, filename)
+	}
+
+	/*
+		Postprocessing.
+	*/
+
+	// Prepend the `StoryTitle` special passage, if necessary.
+	if s.name != "" && !s.has("StoryTitle") {
+		s.prepend(newPassage("StoryTitle", []string{}, s.name))
+	}
+
+	return nil
+}
+
+func (s *Story) loadTwee(filename, encoding string, trim, twee2Compat bool) error {
+	source, err := fileReadAllWithEncoding(filename, encoding, s.reporter)
+	if err != nil {
+		return err
+	}
+
+	passages, err := parseTwee(filename, source, trim, twee2Compat, s.reporter)
+	if err != nil {
+		return err
+	}
+	for _, p := range passages {
+		if err := s.add(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseTwee parses the Twee source, returning its passages in order.  The
+// filename is only used as the provenance of the passages.  Of the options,
+// only NoTrim, Twee2Compat, and Report are used.
+func ParseTwee(filename string, source []byte, opts *Options) ([]*Passage, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return parseTwee(filename, source, !opts.NoTrim, opts.Twee2Compat, opts.Report)
+}
+
+func parseTwee(filename string, source []byte, trim, twee2Compat bool, rep reporter) ([]*Passage, error) {
+	if twee2Compat {
+		source = twee2.ToV3(source)
+	}
+
+	var (
+		passages []*Passage
+		pCount   = 0
+		lastType twlex.ItemType
+		lex      = twlex.NewTweelexer(source)
+	)
+
+ParseLoop:
+	for {
+		p := &Passage{origin: passageOrigin{filename: filename}}
+		for item, ok := lex.NextItem(); ok; item, ok = lex.NextItem() {
+			switch item.Type {
+			case twlex.ItemError:
+				return nil, &Diagnostic{Code: "malformed-twee", Message: fmt.Sprintf("Malformed twee source; %s.", item.Val), Filename: filename, Line: item.Line}
+
+			case twlex.ItemEOF:
+				// Add the final passage, if any.
+				if pCount > 0 {
+					passages = append(passages, p)
+				}
+				break ParseLoop
+
+			case twlex.ItemHeader:
+				pCount++
+				if pCount > 1 {
+					passages = append(passages, p)
+					p = &Passage{origin: passageOrigin{filename: filename}}
+				}
+				p.origin.line = item.Line
+				p.origin.offset = item.Pos
+
+			case twlex.ItemName:
+				p.name = string(bytes.TrimSpace(tweeUnescapeBytes(item.Val)))
+				if len(p.name) == 0 {
+					lex.Drain()
+					return nil, &Diagnostic{Code: "malformed-twee", Message: "Malformed twee source; passage with no name.", Filename: filename, Line: item.Line}
+				}
+
+			case twlex.ItemTags:
+				if lastType != twlex.ItemName {
+					lex.Drain()
+					return nil, &Diagnostic{Code: "malformed-twee", Message: "Malformed twee source; optional tags block must immediately follow the passage name.", Filename: filename, Line: item.Line}
+				}
+				p.tags = strings.Fields(string(tweeUnescapeBytes(item.Val[1 : len(item.Val)-1])))
+
+			case twlex.ItemMetadata:
+				if lastType != twlex.ItemName && lastType != twlex.ItemTags {
+					lex.Drain()
+					return nil, &Diagnostic{Code: "malformed-twee", Message: "Malformed twee source; optional metadata block must immediately follow the passage name or tags block.", Filename: filename, Line: item.Line}
+				}
+				if err := p.unmarshalMetadata(item.Val); err != nil {
+					d := newPassageDiagnostic(SeverityWarning, "malformed-metadata", p, "Malformed twee source; could not decode metadata (reason: %s).", err.Error())
+					d.Line = item.Line
+					rep.report(d)
+				}
+
+			case twlex.ItemContent:
+				p.origin.textLine = item.Line
+				if trim {
+					// Trim whitespace surrounding (leading and trailing) passages.
+					text := bytes.TrimLeftFunc(item.Val, unicode.IsSpace)
+					trimmed := item.Val[:len(item.Val)-len(text)]
+					p.origin.textLine += bytes.Count(trimmed, []byte{'\n'})
+					p.origin.textCol = utf8.RuneCount(trimmed[bytes.LastIndexByte(trimmed, '\n')+1:])
+					p.text = string(bytes.TrimRightFunc(text, unicode.IsSpace))
+				} else {
+					// Do not trim whitespace surrounding passages.
+					p.text = string(item.Val)
+				}
+			}
+
+			lastType = item.Type
+		}
+	}
+
+	return passages, nil
+}
+
+func (s *Story) loadHTML(filename, encoding, selector, creator string) error {
+	source, err := fileReadAllWithEncoding(filename, encoding, s.reporter)
+	if err != nil {
+		return err
+	}
+
+	doc, err := getDocumentTree(bytes.TrimSpace(source))
+	if err != nil {
+		return fmt.Errorf("Malformed HTML source; %s.", err.Error())
+	}
+
+	if stories := getElementsByTag(doc, "tw-storydata"); len(stories) > 0 {
+		// Twine 2 style story data chunk.  Library archives contain several,
+		// one per story, of which one is selected.
+		/*
+			<tw-storydata name="…" startnode="…" creator="…" creator-version="…" ifid="…"
+				zoom="…" format="…" format-version="…" options="…" tags="…" hidden>…</tw-storydata>
+		*/
+		i, err := selectStoryData(filename, stories, selector, s.reporter)
+		if err != nil {
+			return err
+		}
+
+		var (
+			storyData      = stories[i]
+			startnode      int
+			creatorVersion string
+			origins        = htmlElementOrigins(filename, source, twine2PassageTagRe, indexNth(source, []byte("<tw-storydata"), i))
+		)
+
+		// Content attribute processing.
+		for _, a := range storyData.Attr {
+			switch a.Key {
+			case "name":
+				s.name = a.Val
+			case "startnode":
+				if iVal, err := strconv.Atoi(a.Val); err == nil {
+					startnode = iVal
+				} else {
+					s.report(newFileDiagnostic(SeverityWarning, "malformed-html", filename, `Cannot parse "tw-storydata" content attribute "startnode" as an integer; value %q.`, a.Val))
+				}
+			case "creator":
+				// NOTE: The compiling program is recorded as the creator anew
+				// upon output, so only other creators—e.g., Twine 2—are kept.
+				if !strings.EqualFold(a.Val, creator) {
+					s.twine2.creator = a.Val
+				}
+			case "creator-version":
+				creatorVersion = a.Val
+			case "ifid":
+				s.ifid = strings.ToUpper(a.Val) // Force uppercase for consistency.
+			case "zoom":
+				if fVal, err := strconv.ParseFloat(a.Val, 64); err == nil {
+					s.twine2.zoom = fVal
+				} else {
+					s.report(newFileDiagnostic(SeverityWarning, "malformed-html", filename, `Cannot parse "tw-storydata" content attribute "zoom" as a float; value %q.`, a.Val))
+				}
+			case "format":
+				s.twine2.format = a.Val
+			case "format-version":
+				s.twine2.formatVersion = a.Val
+			case "options":
+				// FIXME: I'm unsure whether the `options` content attribute is
+				// intended to be a space delimited list.  That does seem likely,
+				// so we treat it as such for now.
+				for _, opt := range strings.Fields(a.Val) {
+					s.twine2.options[opt] = true
+				}
+			case "tags":
+				s.twine2.tags = strings.Fields(a.Val)
+			}
+		}
+		if s.twine2.creator != "" {
+			s.twine2.creatorVersion = creatorVersion
+		}
+
+		// Node processing.
+		for node := storyData.FirstChild; node != nil; node = node.NextSibling {
+			if node.Type != html.ElementNode {
+				continue
+			}
+
+			var (
+				pid      int
+				name     string
+				tags     []string
+				content  string
+				metadata *passageMetadata
+				origin   = passageOrigin{filename: filename}
+			)
+
+			switch node.Data {
+			case "style", "script", "tw-passagedata":
+				// Keep the element origins in step with the nodes, even if
+				// the node is ultimately skipped.
+				if len(origins) > 0 {
+					origin, origins = origins[0], origins[1:]
+				}
+			}
+
+			switch node.Data {
+			case "style", "script":
+				/*
+					<style role="stylesheet" id="twine-user-stylesheet" type="text/twine-css">…</style>
+
+					<script role="script" id="twine-user-script" type="text/twine-javascript">…</script>
+				*/
+				if node.FirstChild == nil {
+					// skip empty elements
+					continue
+				} else {
+					nodeData := strings.TrimSpace(node.FirstChild.Data)
+					if len(nodeData) == 0 {
+						// NOTE: Skip elements that are empty after trimming; this additional
+						// "empty" check is necessary because most (all?) versions of Twine 2
+						// habitually append newlines to the nodes, so they're almost never
+						// actually empty.
+						continue
+					}
+					if node.Data == "style" {
+						name = "Story Stylesheet"
+						tags = []string{"stylesheet"}
+					} else {
+						name = "Story JavaScript"
+						tags = []string{"script"}
+					}
+					content = nodeData
+				}
+			case "tw-tag":
+				/*
+					<tw-tag name="…" color="…"></tw-tag>
+				*/
+				{
+					var (
+						tagName  string
+						tagColor string
+					)
+					for _, a := range node.Attr {
+						switch a.Key {
+						case "name":
+							tagName = a.Val
+						case "color":
+							tagColor = a.Val
+						}
+					}
+					s.twine2.tagColors[tagName] = tagColor
+				}
+				continue
+			case "tw-passagedata":
+				/*
+					<tw-passagedata pid="…" name="…" tags="…" position="…" size="…">…</tw-passagedata>
+				*/
+				metadata = &passageMetadata{}
+				for _, a := range node.Attr {
+					switch a.Key {
+					case "pid":
+						if iVal, err := strconv.Atoi(a.Val); err == nil {
+							pid = iVal
+						} else {
+							s.report(newFileDiagnostic(SeverityWarning, "malformed-html", filename, `Cannot parse "tw-passagedata" content attribute "pid" as an integer; value %q.`, a.Val))
+						}
+					case "name":
+						name = a.Val
+					case "tags":
+						tags = strings.Fields(a.Val)
+					case "position":
+						metadata.position = a.Val
+					case "size":
+						metadata.size = a.Val
+					}
+				}
+				if pid == startnode {
+					s.twine2.start = name
+				}
+				if node.FirstChild != nil {
+					content = node.FirstChild.Data
+				}
+			default:
+				continue
+			}
+
+			p := newPassage(name, tags, strings.TrimSpace(content))
+			if metadata != nil {
+				p.metadata = metadata
+			}
+			p.origin = origin
+			if err := s.add(p); err != nil {
+				return err
+			}
+		}
+
+		// Prepend the `StoryData` special passage.  Includes the story IFID and Twine 2 metadata.
+		p := newPassage("StoryData", []string{}, string(s.marshalStoryData()))
+		p.origin.filename = filename
+		s.prepend(p)
+	} else if storyData := getElementByID(doc, "store(?:-a|A)rea"); storyData != nil {
+		// Twine 1 style story data chunk.
+		/*
+			<div id="store-area" data-size="…" hidden>…</div>
+		*/
+		origins := htmlElementOrigins(filename, source, twine1PassageTagRe, 0)
+		for node := storyData.FirstChild; node != nil; node = node.NextSibling {
+			if node.Type != html.ElementNode || node.Data != "div" || !hasAttr(node, "tiddler") {
+				continue
+			}
+
+			var (
+				name     string
+				tags     []string
+				content  string
+				metadata = &passageMetadata{}
+				origin   = passageOrigin{filename: filename}
+			)
+			if len(origins) > 0 {
+				origin, origins = origins[0], origins[1:]
+				// NOTE: Tiddler text is escaped onto a single line, so lines
+				// within it cannot be mapped back to the file.
+				origin.textLine = 0
+			}
+
+			/*
+				<div tiddler="…" tags="…" created="…" modified="…" modifier="…" twine-position="…">…</div>
+			*/
+			for _, a := range node.Attr {
+				// NOTE: Ignore the following content attributes: `created`, `modified`, `modifier`.
+				switch a.Key {
+				case "tiddler":
+					name = a.Val
+				case "tags":
+					tags = strings.Fields(a.Val)
+				case "twine-position":
+					metadata.position = a.Val
+				}
+			}
+			if node.FirstChild != nil {
+				content = tiddlerUnescapeString(node.FirstChild.Data)
+			}
+
+			p := newPassage(name, tags, strings.TrimSpace(content))
+			if metadata != nil {
+				p.metadata = metadata
+			}
+			p.origin = origin
+			if err := s.add(p); err != nil {
+				return err
+			}
+		}
+	} else {
+		return fmt.Errorf("Malformed HTML source; story data not found.")
+	}
+
+	return nil
+}
+
+func (s *Story) loadTagged(tag, filename, encoding string) error {
+	source, err := fileReadAllWithEncoding(filename, encoding, s.reporter)
+	if err != nil {
+		return err
+	}
+
+	p := newPassage(
+		filepath.Base(filename),
+		[]string{tag},
+		string(source),
+	)
+	p.origin = passageOrigin{filename: filename, line: 1, textLine: 1}
+	return s.add(p)
+}
+
+func (s *Story) loadMedia(tag, filename string) error {
+	source, err := fileReadAllAsBase64(filename)
+	if err != nil {
+		return err
+	}
+
+	p := newPassage(
+		strings.Split(filepath.Base(filename), ".")[0],
+		[]string{tag},
+		"data:"+mediaTypeFromFilename(filename)+";base64,"+string(source),
+	)
+	p.origin = passageOrigin{filename: filename, line: 1}
+	return s.add(p)
+}
+
+func (s *Story) loadFont(filename string) error {
+	source, err := fileReadAllAsBase64(filename)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(filename)
+	p := newPassage(name, []string{"stylesheet"}, fontFaceRule(name, string(source)))
+	p.origin = passageOrigin{filename: filename, line: 1}
+	return s.add(p)
+}
+
+// fontFaceRule returns the `@font-face` rule for the named font file, whose
+// source is base64 encoded.
+func fontFaceRule(filename, source string) string {
+	var (
+		family    = strings.Split(filepath.Base(filename), ".")[0]
+		ext       = normalizedFileExt(filename)
+		mediaType = mediaTypeFromExt(ext)
+		hint      string
+	)
+	switch ext {
+	case "ttf":
+		hint = "truetype"
+	case "otf":
+		hint = "opentype"
+	default:
+		hint = ext
+	}
+
+	return fmt.Sprintf(
+		"@font-face {\n\tfont-family: %q;\n\tsrc: url(\"data:%s;base64,%s\") format(%q);\n}",
+		family,
+		mediaType,
+		source,
+		hint,
+	)
+}
+
+var (
+	// Matches the start tags of Twine 2 story data elements that become passages.
+	twine2PassageTagRe = regexp.MustCompile(`<(?:tw-passagedata|style|script)\b(?:[^>"']|"[^"]*"|'[^']*')*>`)
+
+	// Matches the start tags of Twine 1 tiddler elements.
+	twine1PassageTagRe = regexp.MustCompile(`<div\s(?:[^>"']|"[^"]*"|'[^']*')*?\btiddler=(?:[^>"']|"[^"]*"|'[^']*')*>`)
+)
+
+// htmlElementOrigins returns the origins, in document order, of the element
+// start tags matched by re within source, beginning at the start offset.  If
+// the start offset is negative, there are none.
+//
+// NOTE: The `x/net/html` package does not track source positions, so the
+// origins must be found separately and matched to the parsed nodes by order.
+func htmlElementOrigins(filename string, source []byte, re *regexp.Regexp, start int) []passageOrigin {
+	if start < 0 {
+		return nil
+	}
+
+	var (
+		origins []passageOrigin
+		last    = 0
+		line    = 1
+	)
+	for _, loc := range re.FindAllIndex(source[start:], -1) {
+		tagStart, tagEnd := start+loc[0], start+loc[1]
+		line += bytes.Count(source[last:tagStart], []byte{'\n'})
+		last = tagStart
+
+		// The text begins after the start tag and any leading whitespace.
+		text := source[tagEnd:]
+		lead := len(text) - len(bytes.TrimLeftFunc(text, unicode.IsSpace))
+		origins = append(origins, passageOrigin{
+			filename: filename,
+			line:     line,
+			textLine: line + bytes.Count(source[tagStart:tagEnd+lead], []byte{'\n'}),
+			offset:   tagStart,
+		})
+	}
+	return origins
+}
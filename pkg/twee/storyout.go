@@ -4,20 +4,21 @@
 	can be found in the LICENSE file.
 */
 
-package main
+package twee
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func (s *story) toTwee(outMode outputMode) []byte {
+func (s *Story) toTwee(outMode OutputMode) []byte {
 	var data []byte
 	for _, p := range s.passages {
 		data = append(data, p.toTwee(outMode)...)
@@ -25,11 +26,15 @@ func (s *story) toTwee(outMode outputMode) []byte {
 	return data
 }
 
-func (s *story) toTwine2Archive(startName string) []byte {
-	return append(s.getTwine2DataChunk(startName), '\n')
+func (s *Story) toTwine2Archive(startName string, opts *Options) ([]byte, error) {
+	data, err := s.getTwine2DataChunk(startName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
 }
 
-func (s *story) toTwine1Archive(startName string) []byte {
+func (s *Story) toTwine1Archive(startName string) []byte {
 	var (
 		count    uint
 		data     []byte
@@ -47,31 +52,43 @@ func (s *story) toTwine1Archive(startName string) []byte {
 	return template
 }
 
-func (s *story) toTwine2HTML(startName string) []byte {
-	var template = s.format.source()
+func (s *Story) toTwine2HTML(startName string, opts *Options) ([]byte, error) {
+	template, err := s.format.source()
+	if err != nil {
+		return nil, err
+	}
 
 	// Story instance replacements.
 	if bytes.Contains(template, []byte("{{STORY_NAME}}")) {
 		template = bytes.Replace(template, []byte("{{STORY_NAME}}"), []byte(htmlEscapeString(s.name)), -1)
 	}
 	if bytes.Contains(template, []byte("{{STORY_DATA}}")) {
-		template = bytes.Replace(template, []byte("{{STORY_DATA}}"), s.getTwine2DataChunk(startName), 1)
+		data, err := s.getTwine2DataChunk(startName, opts)
+		if err != nil {
+			return nil, err
+		}
+		template = bytes.Replace(template, []byte("{{STORY_DATA}}"), data, 1)
 	}
 
-	return template
+	return template, nil
 }
 
-func (s *story) toTwine1HTML(startName string) []byte {
+func (s *Story) toTwine1HTML(startName string, opts *Options) ([]byte, error) {
 	var (
 		formatDir = filepath.Dir(s.format.filename)
 		parentDir = filepath.Dir(formatDir)
-		template  = s.format.source()
+		template  []byte
 		count     uint
 		data      []byte
 		component []byte
 		err       error
 	)
 
+	// Get the story format source.
+	if template, err = s.format.source(); err != nil {
+		return nil, err
+	}
+
 	// Get the story data.
 	data, count = s.getTwine1PassageChunk()
 
@@ -87,7 +104,7 @@ func (s *story) toTwine1HTML(startName string) []byte {
 		if err == nil {
 			template = bytes.Replace(template, search, component, 1)
 		} else if !os.IsNotExist(err) {
-			log.Fatalf("error: %s", err.Error())
+			return nil, err
 		}
 	}
 
@@ -95,7 +112,7 @@ func (s *story) toTwine1HTML(startName string) []byte {
 	if search := []byte(`"ENGINE"`); bytes.Contains(template, search) {
 		component, err = fileReadAllAsUTF8(filepath.Join(parentDir, "engine.js"))
 		if err != nil {
-			log.Fatalf("error: %s", err.Error())
+			return nil, err
 		}
 		template = bytes.Replace(template, search, component, 1)
 	}
@@ -103,7 +120,7 @@ func (s *story) toTwine1HTML(startName string) []byte {
 		if search := []byte(pattern); bytes.Contains(template, search) {
 			component, err = fileReadAllAsUTF8(filepath.Join(formatDir, "code.js"))
 			if err != nil {
-				log.Fatalf("error: %s", err.Error())
+				return nil, err
 			}
 			template = bytes.Replace(template, search, component, 1)
 		}
@@ -112,7 +129,7 @@ func (s *story) toTwine1HTML(startName string) []byte {
 		if search := []byte(`"JQUERY"`); bytes.Contains(template, search) {
 			component, err = fileReadAllAsUTF8(filepath.Join(parentDir, "jquery.js"))
 			if err != nil {
-				log.Fatalf("error: %s", err.Error())
+				return nil, err
 			}
 			template = bytes.Replace(template, search, component, 1)
 		}
@@ -121,18 +138,18 @@ func (s *story) toTwine1HTML(startName string) []byte {
 		if search := []byte(`"MODERNIZR"`); bytes.Contains(template, search) {
 			component, err = fileReadAllAsUTF8(filepath.Join(parentDir, "modernizr.js"))
 			if err != nil {
-				log.Fatalf("error: %s", err.Error())
+				return nil, err
 			}
 			template = bytes.Replace(template, search, component, 1)
 		}
 	}
 
 	// Story instance replacements.
-	if startName == defaultStartName {
+	if startName == DefaultStartName {
 		startName = ""
 	}
 	template = bytes.Replace(template, []byte(`"VERSION"`),
-		[]byte(fmt.Sprintf("Compiled with %s, %s", tweegoName, tweegoVersion.Version())), 1)
+		[]byte(fmt.Sprintf("Compiled with %s, %s", opts.creator(), opts.CreatorVersion)), 1)
 	template = bytes.Replace(template, []byte(`"TIME"`),
 		[]byte(fmt.Sprintf("Built on %s", time.Now().Format(time.RFC1123Z))), 1)
 	template = bytes.Replace(template, []byte(`"START_AT"`),
@@ -150,7 +167,7 @@ func (s *story) toTwine1HTML(startName string) []byte {
 			if os.IsNotExist(err) {
 				footer = []byte("</div>\n</body>\n</html>\n")
 			} else {
-				log.Fatalf("error: %s", err.Error())
+				return nil, err
 			}
 		}
 		template = append(template, data...)
@@ -170,10 +187,10 @@ func (s *story) toTwine1HTML(startName string) []byte {
 		}
 	}
 
-	return template
+	return template, nil
 }
 
-func (s *story) getTwine2DataChunk(startName string) []byte {
+func (s *Story) getTwine2DataChunk(startName string, opts *Options) ([]byte, error) {
 	var (
 		data    []byte
 		startID string
@@ -182,46 +199,14 @@ func (s *story) getTwine2DataChunk(startName string) []byte {
 	)
 
 	// Check the IFID status.
-	if s.ifid == "" {
-		var (
-			ifid string
-			err  error
-		)
-		if s.legacyIFID != "" {
-			/*
-				LEGACY
-			*/
-			log.Print(`error: Story IFID not found; reusing "ifid" entry from the "StorySettings" special passage.`)
-			log.Println()
-			ifid = s.legacyIFID
-			/*
-				END LEGACY
-			*/
-		} else {
-			log.Print("error: Story IFID not found; generating one for your project.")
-			log.Println()
-			ifid, err = newIFID()
-			if err != nil {
-				log.Fatalf("error: IFID generation failed; %s", err.Error())
-			}
-		}
-		ifid = fmt.Sprintf(`"ifid": %q`, ifid)
-		base := "Copy the following "
-		if s.has("StoryData") {
-			ifid += ","
-			log.Printf("%sline into the \"StoryData\" special passage's JSON block (at the top):\n\n\t%s\n\n", base, ifid)
-			log.Printf("E.g., it should look something like the following:\n\n:: StoryData\n%s\n\n",
-				bytes.Replace(s.marshalStoryData(), []byte("{"), []byte("{\n\t"+ifid), 1))
-		} else {
-			log.Printf("%s\"StoryData\" special passage into one of your project's twee source files:\n\n:: StoryData\n{\n\t%s\n}", base, ifid)
-		}
-		log.Fatalln()
+	if err := s.checkIFID(); err != nil {
+		return nil, err
 	}
 
 	// Gather all script and stylesheet passages.
 	var (
-		scripts     = make([]*passage, 0, 4)
-		stylesheets = make([]*passage, 0, 4)
+		scripts     = make([]*Passage, 0, 4)
+		stylesheets = make([]*Passage, 0, 4)
 	)
 	for _, p := range s.passages {
 		if p.tagsHas("Twine.private") {
@@ -278,30 +263,21 @@ func (s *story) getTwine2DataChunk(startName string) []byte {
 	/*
 		<tw-tag name="…" color="…"></tw-tag>
 	*/
-	if s.twine2.tagColors != nil {
-		for tag, color := range s.twine2.tagColors {
-			data = append(data, fmt.Sprintf(`<tw-tag name=%q color=%q></tw-tag>`, tag, color)...)
+	if len(s.twine2.tagColors) > 0 {
+		// NOTE: Sort the tags, so that the output is deterministic.
+		tags := make([]string, 0, len(s.twine2.tagColors))
+		for tag := range s.twine2.tagColors {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			data = append(data, fmt.Sprintf(`<tw-tag name=%q color=%q></tw-tag>`, attrEscapeString(tag), attrEscapeString(s.twine2.tagColors[tag]))...)
 		}
 	}
 
 	// Prepare normal passage elements.
 	pid = 1
-	for _, p := range s.passages {
-		if p.name == "StoryTitle" || p.name == "StoryData" || p.tagsHasAny("script", "stylesheet", "Twine.private") {
-			continue
-		}
-
-		/*
-			LEGACY
-		*/
-		// TODO: Should we actually drop an empty StorySettings passage?
-		if p.name == "StorySettings" && len(s.twine1.settings) == 0 {
-			continue
-		}
-		/*
-			END LEGACY
-		*/
-
+	for _, p := range s.getTwine2Passages() {
 		data = append(data, p.toPassagedata(pid)...)
 		if startName == p.name {
 			startID = fmt.Sprint(pid)
@@ -312,37 +288,107 @@ func (s *story) getTwine2DataChunk(startName string) []byte {
 	// Add the <tw-storydata> wrapper.
 	/*
 		<tw-storydata name="…" startnode="…" creator="…" creator-version="…" ifid="…"
-			zoom="…" format="…" format-version="…" options="…" hidden>…</tw-storydata>
+			zoom="…" format="…" format-version="…" options="…" tags="…" hidden>…</tw-storydata>
 	*/
-	if optCount := len(s.twine2.options); optCount > 0 {
-		opts := make([]string, 0, optCount)
-		for opt, val := range s.twine2.options {
-			if val {
-				opts = append(opts, opt)
-			}
-		}
-		options = strings.Join(opts, " ")
-	}
+	options = strings.Join(twine2OptionsMapToSlice(s.twine2.options), " ")
+	creator, creatorVersion := s.twine2Creator(opts)
 	data = append([]byte(fmt.Sprintf(
 		`<!-- UUID://%s// -->`+
-			`<tw-storydata name=%q startnode=%q creator=%q creator-version=%q ifid=%q zoom=%q format=%q format-version=%q options=%q hidden>`,
+			`<tw-storydata name=%q startnode=%q creator=%q creator-version=%q ifid=%q zoom=%q format=%q format-version=%q options=%q tags=%q hidden>`,
 		s.ifid,
 		attrEscapeString(s.name),
 		startID,
-		attrEscapeString(strings.Title(tweegoName)),
-		attrEscapeString(tweegoVersion.Version()),
+		attrEscapeString(creator),
+		attrEscapeString(creatorVersion),
 		attrEscapeString(s.ifid),
 		attrEscapeString(strconv.FormatFloat(s.twine2.zoom, 'f', -1, 32)),
 		attrEscapeString(s.format.name),
 		attrEscapeString(s.format.version),
 		attrEscapeString(options),
+		attrEscapeString(strings.Join(s.twine2.tags, " ")),
 	)), data...)
 	data = append(data, `</tw-storydata>`...)
 
-	return data
+	return data, nil
+}
+
+// twine2Creator returns the creator and creator version recorded within the
+// Twine 2 story data—i.e., those the story was loaded with, if it was created
+// by another program, elsewise those of the compiling program.
+func (s *Story) twine2Creator(opts *Options) (string, string) {
+	if s.twine2.creator != "" {
+		return s.twine2.creator, s.twine2.creatorVersion
+	}
+	return strings.Title(opts.creator()), opts.CreatorVersion
+}
+
+// checkIFID checks that the story has an IFID, which the Twine 2 story data
+// requires.  If not, the returned error instructs the user how to add one.
+func (s *Story) checkIFID() error {
+	if s.ifid != "" {
+		return nil
+	}
+
+	var (
+		ifid string
+		msg  string
+		err  error
+	)
+	if s.legacyIFID != "" {
+		/*
+			LEGACY
+		*/
+		msg = `Story IFID not found; reusing "ifid" entry from the "StorySettings" special passage.` + "\n\n"
+		ifid = s.legacyIFID
+		/*
+			END LEGACY
+		*/
+	} else {
+		msg = "Story IFID not found; generating one for your project.\n\n"
+		ifid, err = newIFID()
+		if err != nil {
+			return fmt.Errorf("IFID generation failed; %s", err.Error())
+		}
+	}
+	ifid = fmt.Sprintf(`"ifid": %q`, ifid)
+	base := "Copy the following "
+	if s.has("StoryData") {
+		ifid += ","
+		msg += fmt.Sprintf("%sline into the \"StoryData\" special passage's JSON block (at the top):\n\n\t%s\n\n", base, ifid)
+		msg += fmt.Sprintf("E.g., it should look something like the following:\n\n:: StoryData\n%s\n",
+			bytes.Replace(s.marshalStoryData(), []byte("{"), []byte("{\n\t"+ifid), 1))
+	} else {
+		msg += fmt.Sprintf("%s\"StoryData\" special passage into one of your project's twee source files:\n\n:: StoryData\n{\n\t%s\n}\n", base, ifid)
+	}
+	return errors.New(msg)
+}
+
+// getTwine2Passages returns the passages which become normal passage elements
+// within the Twine 2 story data chunk.
+func (s *Story) getTwine2Passages() []*Passage {
+	var passages []*Passage
+	for _, p := range s.passages {
+		if p.name == "StoryTitle" || p.name == "StoryData" || p.tagsHasAny("script", "stylesheet", "Twine.private") {
+			continue
+		}
+
+		/*
+			LEGACY
+		*/
+		// TODO: Should we actually drop an empty StorySettings passage?
+		if p.name == "StorySettings" && len(s.twine1.settings) == 0 {
+			continue
+		}
+		/*
+			END LEGACY
+		*/
+
+		passages = append(passages, p)
+	}
+	return passages
 }
 
-func (s *story) getTwine1PassageChunk() ([]byte, uint) {
+func (s *Story) getTwine1PassageChunk() ([]byte, uint) {
 	var (
 		data  []byte
 		count uint
@@ -0,0 +1,316 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SplitMode is how the passages of a decompiled project tree are grouped into
+// Twee files.
+type SplitMode int
+
+const (
+	SplitByPassage SplitMode = iota // One Twee file per passage.
+	SplitByTag                      // One Twee file per set of tags.
+)
+
+// TreeFile is a file of a decompiled project tree.
+type TreeFile struct {
+	Name string // Slash-separated path of the file, relative to the root of the tree.
+	Data []byte
+}
+
+// Directories of the decompiled project tree, by kind of file.
+const (
+	treeFontsDir   = "fonts"
+	treeMediaDir   = "media"
+	treeScriptsDir = "scripts"
+	treeStylesDir  = "styles"
+)
+
+// Matches the text of passages generated by `loadFont()`, see `fontFaceRule()`.
+var fontPassageRe = regexp.MustCompile(`\A@font-face \{\n\tfont-family: "[^"\n]*";\n\tsrc: url\("data:font/[\w.+-]+;base64,([A-Za-z0-9+/=]*)"\) format\("[\w-]*"\);\n\}\z`)
+
+// Matches base64 data URIs—e.g., those generated by `loadMedia()`.
+var dataURIRe = regexp.MustCompile(`\Adata:([\w.+-]+/[\w.+-]+);base64,([A-Za-z0-9+/=]*)\z`)
+
+// DecompileTree decompiles the story into a Twee 3 project tree, which loads
+// back into an equivalent story.  Passages are grouped into Twee files per the
+// split mode, while passages originally loaded from stylesheet, script, font,
+// or media files—i.e., those whose names, tags, and text could have been
+// generated by `loadTagged()`, `loadFont()`, or `loadMedia()`—are written
+// back out as such files.  Of the options, only SplitBy, StrictLinks, and
+// Report are used.
+func (s *Story) DecompileTree(opts *Options) ([]*TreeFile, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s.reporter = opts.Report
+
+	// Check the story passages for broken links.
+	if err := s.checkLinks(opts.StrictLinks); err != nil {
+		return nil, err
+	}
+
+	var (
+		files  []*TreeFile
+		groups = make(map[string]*TreeFile)
+		names  = newTreeNames()
+	)
+	for _, p := range s.passages {
+		if name, data, ok := p.toAssetFile(); ok {
+			files = append(files, &TreeFile{Name: names.unique(name), Data: data})
+			continue
+		}
+		s.reportInlineMedia(p)
+
+		var group string
+		switch opts.SplitBy {
+		case SplitByTag:
+			group = "story"
+			if len(p.tags) > 0 {
+				tags := append([]string(nil), p.tags...)
+				sort.Strings(tags)
+				group = strings.Join(tags, " ")
+			}
+		default:
+			group = p.name
+		}
+		f, ok := groups[group]
+		if !ok {
+			f = &TreeFile{Name: names.unique(SafeFilename(group) + ".tw")}
+			groups[group] = f
+			files = append(files, f)
+		}
+		f.Data = append(f.Data, p.toTwee(OutModeTwee3)...)
+	}
+
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, ".tw") {
+			f.Data = append(trimTrailingNewlines(f.Data), '\n')
+		}
+		if !isBinaryTreeFile(f.Name) {
+			f.Data = alignRecordSeparators(f.Data)
+		}
+	}
+
+	return files, nil
+}
+
+// toAssetFile returns the name, within the project tree, and data of the file
+// the passage would have been loaded from, if it's a stylesheet, script, font,
+// or media passage which would be loaded back unchanged.
+func (p *Passage) toAssetFile() (string, []byte, bool) {
+	if len(p.tags) != 1 {
+		return "", nil, false
+	}
+
+	switch tag := p.tags[0]; tag {
+	case "script", "stylesheet":
+		ext, dir := ".js", treeScriptsDir
+		if tag == "stylesheet" {
+			if data, ok := p.fontData(); ok {
+				return treeFontsDir + "/" + SafeFilename(p.name), data, true
+			}
+			ext, dir = ".css", treeStylesDir
+		}
+		// NOTE: `loadTagged()` names passages after their file, so passages
+		// whose names lack the extension—e.g., the `Story JavaScript` passage
+		// of Twine 2 stories—will be loaded back with it.
+		name := SafeFilename(p.name)
+		if !strings.HasSuffix(strings.ToLower(name), ext) {
+			name += ext
+		}
+		return dir + "/" + name, []byte(p.text), true
+
+	default:
+		if isMediaTag(tag) {
+			return p.toMediaFile()
+		}
+	}
+
+	return "", nil, false
+}
+
+// toMediaFile returns the name, within the project tree, and data of the media
+// file the passage would have been loaded from, if it's a media passage which
+// would be loaded back unchanged.
+func (p *Passage) toMediaFile() (string, []byte, bool) {
+	if len(p.tags) != 1 {
+		return "", nil, false
+	}
+	ext, data, ok := p.mediaData()
+	// NOTE: `loadMedia()` names passages after their file, sans everything
+	// from the first period, and tags them per the kind of media.
+	if !ok || mediaTagFromExt(ext) != p.tags[0] || strings.Contains(p.name, ".") || SafeFilename(p.name) != p.name {
+		return "", nil, false
+	}
+	return treeMediaDir + "/" + p.name + "." + ext, data, true
+}
+
+// ExtractMedia removes the media passages whose text is a base64 data URI—i.e.,
+// those which would be loaded back unchanged from media files—from the story,
+// returning them as the files of a project tree, within its media directory.
+// Decompiling the story afterward yields Twee sources free of encoded media,
+// which load back into an equivalent story along with the files.  Media
+// passages which must be kept inline are reported.  Of the options, only
+// Report is used.
+func (s *Story) ExtractMedia(opts *Options) []*TreeFile {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s.reporter = opts.Report
+
+	var (
+		files    []*TreeFile
+		passages = s.passages[:0]
+		names    = newTreeNames()
+	)
+	for _, p := range s.passages {
+		if name, data, ok := p.toMediaFile(); ok {
+			files = append(files, &TreeFile{Name: names.unique(name), Data: data})
+			continue
+		}
+		s.reportInlineMedia(p)
+		passages = append(passages, p)
+	}
+	for i := len(passages); i < len(s.passages); i++ {
+		s.passages[i] = nil
+	}
+	s.passages = passages
+	return files
+}
+
+// reportInlineMedia reports the passage, if it's a media passage whose text is
+// a base64 data URI, as being kept inline—i.e., its name contains a period or
+// characters unsafe within filenames, or its tag doesn't match its media type.
+func (s *Story) reportInlineMedia(p *Passage) {
+	if len(p.tags) != 1 || !isMediaTag(p.tags[0]) {
+		return
+	}
+	if _, _, ok := p.mediaData(); !ok {
+		return
+	}
+	s.report(newPassageDiagnostic(SeverityWarning, "inline-media", p, "Media passage %q kept inline as a data URI, as it would not load back unchanged from a media file.", p.name))
+}
+
+// fontData returns the decoded font of a passage generated by `loadFont()`.
+func (p *Passage) fontData() ([]byte, bool) {
+	switch normalizedFileExt(p.name) {
+	case "otf", "ttf", "woff", "woff2":
+	default:
+		return nil, false
+	}
+	m := fontPassageRe.FindStringSubmatch(p.text)
+	if m == nil || p.text != fontFaceRule(p.name, m[1]) {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// mediaData returns the file extension and decoded media of a passage whose
+// text is a base64 data URI of a known media type.
+func (p *Passage) mediaData() (string, []byte, bool) {
+	m := dataURIRe.FindStringSubmatch(p.text)
+	if m == nil {
+		return "", nil, false
+	}
+	ext := extFromMediaType(m[1])
+	if ext == "" {
+		return "", nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return "", nil, false
+	}
+	return ext, data, true
+}
+
+// isBinaryTreeFile reports whether the project tree file is a font or media
+// file.
+func isBinaryTreeFile(name string) bool {
+	return strings.HasPrefix(name, treeFontsDir+"/") || strings.HasPrefix(name, treeMediaDir+"/")
+}
+
+// isMediaTag reports whether the tag is that of media passages.
+func isMediaTag(tag string) bool {
+	switch tag {
+	case "Twine.audio", "Twine.image", "Twine.video", "Twine.vtt":
+		return true
+	}
+	return false
+}
+
+// mediaTagFromExt returns the tag of the passages `loadMedia()` generates for
+// files with the extension.
+func mediaTagFromExt(ext string) string {
+	mediaType := mediaTypeFromExt(ext)
+	switch {
+	case mediaType == "text/vtt":
+		return "Twine.vtt"
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "Twine.audio"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "Twine.image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "Twine.video"
+	}
+	return ""
+}
+
+func trimTrailingNewlines(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] == '\n' {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// Matches characters which are invalid within filenames on common filesystems.
+var unsafeFilenameRe = regexp.MustCompile(`[\x00-\x1f<>:"/\\|?*]`)
+
+// SafeFilename returns the name with characters which are invalid within
+// filenames replaced—e.g., for naming files after passages or stories.
+func SafeFilename(name string) string {
+	name = unsafeFilenameRe.ReplaceAllLiteralString(name, "_")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// treeNames tracks the names of the files of a project tree, so that no two
+// differ only by case, which would collide on case-insensitive filesystems.
+type treeNames map[string]bool
+
+func newTreeNames() treeNames {
+	return make(treeNames)
+}
+
+// unique returns the name, suffixed with a number if it's already in use.
+func (names treeNames) unique(name string) string {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > strings.LastIndex(name, "/")+1 {
+		base, ext = name[:i], name[i:]
+	}
+	candidate := name
+	for n := 2; names[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	names[strings.ToLower(candidate)] = true
+	return candidate
+}
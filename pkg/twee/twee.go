@@ -0,0 +1,340 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+/*
+Package twee implements the Tweego compiler.  It loads Twee, Twine 2, and
+Twine 1 sources—along with stylesheets, scripts, fonts, and media—into stories
+and compiles stories to HTML, Twee, Twine archives, or passage link graphs.
+
+	s, err := twee.Load([]string{"src"}, opts)
+	if err != nil {
+		// …
+	}
+	if err := s.Compile(w, opts); err != nil {
+		// …
+	}
+
+Errors are returned, never fatal.  Errors associated with a kind of problem or
+a position within a source file are of type *Diagnostic, and multiple errors
+are returned as an Errors value.  Warnings are reported as diagnostics via
+Options.Report, elsewise they're written to the standard logger.
+*/
+package twee
+
+import (
+	// standard packages
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OutputMode is the kind of output a story is compiled to.
+type OutputMode int
+
+const (
+	OutModeHTML          OutputMode = iota // Compiled HTML.
+	OutModeTwee3                           // Twee 3 source.
+	OutModeTwee1                           // Twee 1 source.
+	OutModeTwine2Archive                   // Twine 2 archived HTML.
+	OutModeTwine1Archive                   // Twine 1 archived HTML.
+	OutModeGraphDOT                        // Passage link graph as Graphviz DOT.
+	OutModeGraphJSON                       // Passage link graph as JSON.
+	OutModeTwine2JSON                      // Twine 2 story data as JSON, in the style of Twison.
+	OutModeProofing                        // Proofing copy, compiled with a proofing format.
+)
+
+const (
+	DefaultFormatID  = "sugarcube-2"
+	DefaultStartName = "Start"
+
+	defaultProofingName = "Paperthin"
+
+	defaultCreator = "tweego"
+)
+
+// Options are the options for loading and compiling stories.  The zero value
+// is usable, save for compiling, which also requires Formats.
+type Options struct {
+	// Loading.
+	Encoding     string   // Charset of the input sources, see ReadFile.
+	NoTrim       bool     // Disable trimming of the whitespace surrounding passages.
+	Twee2Compat  bool     // Enable Twee2 source compatibility mode for all Twee sources.
+	ExcludePaths []string // Paths to exclude from the input sources.
+	ExcludeTags  []string // Tags whose passages are excluded from the story.
+	Story        string   // Name or IFID of the story to load from Twine 2 library archives, see ListStories; by default, the first.
+	OutFile      string   // Name of the output file, which cannot be an input source.
+	Cache        *Cache   // Build cache to use, if any.
+
+	// Compiling.
+	OutMode          OutputMode
+	Formats          Formats   // Available story formats.
+	FormatID         string    // ID of the story format; by default, the one set by the story data, elsewise DefaultFormatID.
+	ProofingFormatID string    // ID or name of the proofing format; by default, Paperthin, if available, elsewise the first available proofing format.
+	StartName        string    // Name of the starting passage, see (*Story).StartName.
+	TestMode         bool      // Enable test mode; only for story formats in the Twine 2 style.
+	StrictLinks      bool      // Treat broken passage links as errors, rather than warnings.
+	ExemptTags       []string  // Tags exempting passages from the reachability reports.
+	ModulePaths      []string  // Module sources, which are added to the <head> element of compiled HTML.
+	HeadFile         string    // Name of a file whose contents are added to the <head> element of compiled HTML.
+	Creator          string    // Name of the compiling program, recorded within the output; by default, "tweego".
+	CreatorVersion   string    // Version of the compiling program, recorded within the output.
+	SplitBy          SplitMode // How passages are grouped into Twee files, see (*Story).DecompileTree.
+
+	// Report, if set, is called with the diagnostic of each warning found while
+	// loading or compiling, elsewise warnings are written to the standard logger.
+	Report func(*Diagnostic)
+}
+
+func (opts *Options) getFilenames(pathnames []string) ([]string, error) {
+	var (
+		filenames []string
+		err       error
+	)
+	if opts.Cache != nil {
+		filenames, err = opts.Cache.getFilenames(pathnames, opts.OutFile, opts.Report)
+	} else {
+		filenames, err = getFilenames(pathnames, opts.OutFile, opts.Report)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return excludeFilenames(filenames, opts.ExcludePaths, opts.Report), nil
+}
+
+func (opts *Options) creator() string {
+	if opts.Creator == "" {
+		return defaultCreator
+	}
+	return opts.Creator
+}
+
+// FormatUnavailableError is returned when the requested story format is not
+// available.
+type FormatUnavailableError struct {
+	msg string
+}
+
+func (e *FormatUnavailableError) Error() string {
+	return e.msg
+}
+
+// Load walks the pathnames, loading the files found into a new story.
+func Load(pathnames []string, opts *Options) (*Story, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	filenames, err := opts.getFilenames(pathnames)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStory()
+	s.excludeTags = opts.ExcludeTags
+	s.reporter = opts.Report
+	if err := s.load(filenames, opts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// StartName returns the name of the starting passage—i.e., the given name,
+// if not empty, elsewise the one set by the story data, if any, elsewise
+// DefaultStartName.
+func (s *Story) StartName(name string) string {
+	switch {
+	case name != "":
+		return name
+	case s.twine2.start != "":
+		return s.twine2.start
+	default:
+		return DefaultStartName
+	}
+}
+
+// Compile compiles the story, writing the output to w.  Nothing is written if
+// compiling fails.
+func (s *Story) Compile(w io.Writer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s.reporter = opts.Report
+
+	// Check the story passages for broken links.
+	if err := s.checkLinks(opts.StrictLinks); err != nil {
+		return err
+	}
+
+	// Finalize the story setup.
+	if err := s.selectFormat(opts); err != nil {
+		return err
+	}
+	s.twine2.options["debug"] = s.twine2.options["debug"] || opts.TestMode
+	startName := s.StartName(opts.StartName)
+
+	// Generate the output.
+	var (
+		output []byte
+		err    error
+	)
+	s.externalFiles = nil
+	switch opts.OutMode {
+	case OutModeTwee3, OutModeTwee1:
+		// Generate the project as Twee source.
+		output = alignRecordSeparators(s.toTwee(opts.OutMode))
+	case OutModeTwine2Archive:
+		// Generate the project as Twine 2 archived HTML.
+		if output, err = s.toTwine2Archive(startName, opts); err != nil {
+			return err
+		}
+	case OutModeTwine2JSON:
+		// Generate the project as Twine 2 JSON.
+		if output, err = s.toTwine2JSON(startName, opts); err != nil {
+			return err
+		}
+	case OutModeGraphDOT:
+		// Generate the passage link graph as Graphviz DOT.
+		output = alignRecordSeparators(s.toGraphDOT(startName))
+	case OutModeGraphJSON:
+		// Generate the passage link graph as JSON.
+		output = s.toGraphJSON(startName, opts.ExemptTags)
+	case OutModeTwine1Archive:
+		// Generate the project as Twine 1 archived HTML.
+		output = s.toTwine1Archive(startName)
+	default:
+		// Basic sanity checks.  Proofing copies have no need of a starting passage.
+		if opts.OutMode != OutModeProofing && !s.has(startName) {
+			return &Diagnostic{Code: "missing-start", Message: fmt.Sprintf("Starting passage %q not found.", startName)}
+		}
+		if (s.format.IsTwine1Style() || s.name == "") && !s.has("StoryTitle") {
+			return &Diagnostic{Code: "missing-story-title", Message: `Special passage "StoryTitle" not found.`}
+		}
+
+		if s.format.IsTwine2Style() {
+			// Generate the project as Twine 2 compiled HTML.
+			output, err = s.toTwine2HTML(startName, opts)
+		} else {
+			// Generate the project as Twine 1 compiled HTML.
+			output, err = s.toTwine1HTML(startName, opts)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Add the modules and head file, if any.
+		var modulePaths []string
+		if len(opts.ModulePaths) > 0 {
+			if modulePaths, err = opts.getFilenames(opts.ModulePaths); err != nil {
+				return err
+			}
+		}
+		if output, err = s.modifyHead(output, modulePaths, opts.HeadFile, opts.Encoding); err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(output)
+	return err
+}
+
+// selectFormat selects the story format to compile the story with.
+func (s *Story) selectFormat(opts *Options) error {
+	if opts.OutMode == OutModeProofing {
+		return s.selectProofingFormat(opts)
+	}
+
+	var (
+		id  string
+		err error
+	)
+	switch {
+	case opts.FormatID != "":
+		id = opts.FormatID
+		if i := strings.LastIndex(id, "@"); i != -1 {
+			// The story format ID or name, constrained to a version—e.g., `sugarcube-2@~2.36`.
+			name, version := id[:i], id[i+1:]
+			if f := opts.Formats.getByID(name); f != nil && f.twine2 {
+				name = f.name
+			}
+			if id, err = s.selectTwine2Format(opts.Formats, name, version, ""); err != nil {
+				return err
+			}
+		}
+	case s.twine2.format != "":
+		if id, err = s.selectTwine2Format(opts.Formats, s.twine2.format, s.twine2.formatVersion, "StoryData"); err != nil {
+			return err
+		}
+	default:
+		id = DefaultFormatID
+	}
+	if !opts.Formats.hasByID(id) {
+		return &FormatUnavailableError{fmt.Sprintf("Story format %q is not available.", id)}
+	}
+
+	s.format = opts.Formats.getByID(id)
+	return nil
+}
+
+// selectProofingFormat selects the proofing format to compile the story with—
+// i.e., the one with the given ID or name, elsewise Paperthin, if available,
+// elsewise the first available proofing format, by ID.
+func (s *Story) selectProofingFormat(opts *Options) error {
+	var f *Format
+	if opts.ProofingFormatID != "" {
+		if f = opts.Formats.getByID(opts.ProofingFormatID); f == nil {
+			f = opts.Formats.getByTwine2Name(opts.ProofingFormatID)
+		}
+		if f == nil {
+			return &FormatUnavailableError{fmt.Sprintf("Proofing format %q is not available.", opts.ProofingFormatID)}
+		}
+		if !f.proofing {
+			return &FormatUnavailableError{fmt.Sprintf("Story format %q is not a proofing format.", f.id)}
+		}
+	} else {
+		if f = opts.Formats.getByTwine2Name(defaultProofingName); f == nil || !f.proofing {
+			f = nil
+			ids := opts.Formats.IDs()
+			sort.Strings(ids)
+			for _, id := range ids {
+				if opts.Formats[id].proofing {
+					f = opts.Formats[id]
+					break
+				}
+			}
+		}
+		if f == nil {
+			return &FormatUnavailableError{"Proofing formats are not available."}
+		}
+	}
+
+	s.format = f
+	return nil
+}
+
+// selectTwine2Format returns the ID of the greatest version of the named story
+// format which satisfies the wanted version—either a version or a SemVer
+// constraint expression.  If none do, the returned error lists why each of the
+// installed versions was rejected.
+func (s *Story) selectTwine2Format(formats Formats, name, version, passage string) (string, error) {
+	if _, err := newTwine2VersionFilter(version); err != nil {
+		s.report(&Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "format-version",
+			Message:  fmt.Sprintf("Story format %q: Auto-selecting greatest version; Could not parse version %q.", name, version),
+			Passage:  passage,
+		})
+	}
+	id, rejected := formats.selectTwine2NameAndVersion(name, version)
+	if id == "" {
+		msg := fmt.Sprintf("Story format named %q at version %q is not available.", name, version)
+		if len(rejected) > 0 {
+			msg += "\nRejected story formats:\n  " + strings.Join(rejected, "\n  ")
+		}
+		return "", &FormatUnavailableError{msg}
+	}
+	return id, nil
+}
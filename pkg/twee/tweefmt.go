@@ -0,0 +1,87 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package twee
+
+import (
+	// standard packages
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FormatTwee returns the Twee 3 source in canonical form.  The filename is only
+// used as the provenance of diagnostics.  Of the options, only Report is used.
+//
+// The canonical form is that of the Twee 3 decompiler: passage headers with
+// single spaces between their parts, sorted tags, and metadata in key order,
+// passages separated by two blank lines, and a single trailing newline.  The
+// order of the passages and their text—save for the blank lines ending each,
+// which separate it from the next—are preserved byte-for-byte, as is any text
+// preceding the first passage.
+func FormatTwee(filename string, source []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// Undecodable metadata would be lost, so treat it as an error.
+	var metaErr *Diagnostic
+	passages, err := parseTwee(filename, source, false, false, func(d *Diagnostic) {
+		if d.Code == "malformed-metadata" && metaErr == nil {
+			d.Severity = SeverityError
+			metaErr = d
+			return
+		}
+		reporter(opts.Report).report(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if metaErr != nil {
+		return nil, metaErr
+	}
+
+	var buf bytes.Buffer
+
+	// Text preceding the first passage is ignored by the lexer, so keep it
+	// as-is, sans trailing whitespace.
+	prolog := source
+	if len(passages) > 0 {
+		prolog = source[:passages[0].origin.offset]
+	}
+	if prolog = bytes.TrimRightFunc(prolog, unicode.IsSpace); len(prolog) > 0 {
+		buf.Write(prolog)
+		buf.WriteString("\n\n\n")
+	}
+
+	for _, p := range passages {
+		if len(p.tags) > 1 {
+			tags := append([]string(nil), p.tags...)
+			sort.Strings(tags)
+			p.tags = tags
+		}
+		p.text = trimTrailingBlankLines(p.text)
+		buf.WriteString(p.toTwee(OutModeTwee3))
+	}
+
+	formatted := bytes.TrimRight(buf.Bytes(), "\n")
+	if len(formatted) == 0 {
+		return formatted, nil
+	}
+	return append(formatted, '\n'), nil
+}
+
+// trimTrailingBlankLines returns the text sans the blank lines—i.e., those which
+// are empty or only whitespace—which end it.  Whitespace within the final line
+// which is not blank, including trailing whitespace, is kept.
+func trimTrailingBlankLines(text string) string {
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+	if i := strings.IndexByte(text[len(trimmed):], '\n'); i != -1 {
+		return text[:len(trimmed)+i]
+	}
+	return text
+}
@@ -0,0 +1,141 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+const (
+	defaultServeAddr = "localhost:8080"
+
+	// URL path of the server-sent events endpoint used by the reload client.
+	serveEventsPath = "/__tweego/events"
+)
+
+// The reload client, which is injected into the <head> element of the output
+// file as it's served, reloads the page upon receipt of a reload event.
+var serveReloadClient = []byte(`<script id="tweego-live-reload">(function () {
+	var events = new EventSource("` + serveEventsPath + `");
+	events.addEventListener("reload", function () { location.reload(); });
+}());</script>`)
+
+// devServer is the live-reload development server.  It serves the output
+// file, along with any other files within its directory.
+type devServer struct {
+	outFile string
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+// newDevServer creates a new development server instance for the output file.
+func newDevServer(outFile string) *devServer {
+	return &devServer{
+		outFile: outFile,
+		clients: make(map[chan struct{}]bool),
+	}
+}
+
+// start starts listening on the address and serving requests in the background.
+func (srv *devServer) start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(serveEventsPath, srv.serveEvents)
+	mux.HandleFunc("/", srv.serveFiles)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Fatalf("error: serve: %s", err.Error())
+		}
+	}()
+
+	log.Printf("Serving %s at http://%s/", relPath(srv.outFile), listener.Addr().String())
+	return nil
+}
+
+// reload notifies all connected clients that they should reload.
+func (srv *devServer) reload() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for client := range srv.clients {
+		// Never block, a pending notification is as good as a new one.
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (srv *devServer) serveFiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	outDir, outBase := filepath.Split(srv.outFile)
+	if r.URL.Path != "/" && path.Clean(r.URL.Path) != "/"+outBase {
+		http.FileServer(http.Dir(outDir)).ServeHTTP(w, r)
+		return
+	}
+
+	data, err := twee.ReadFile(srv.outFile, "utf-8")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(injectReloadClient(data))
+}
+
+func (srv *devServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan struct{}, 1)
+	srv.mu.Lock()
+	srv.clients[client] = true
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.clients, client)
+		srv.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "event: reload\ndata: \n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func injectReloadClient(data []byte) []byte {
+	tags := append(append([]byte(nil), serveReloadClient...), "\n</head>"...)
+	return bytes.Replace(data, []byte("</head>"), tags, 1)
+}
@@ -7,46 +7,51 @@
 package main
 
 import (
+	// standard packages
+	"fmt"
 	"log"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
 )
 
-// statistics are collected statistics about the compiled story.
-type statistics struct {
-	files struct {
-		project  []string // Project source files.
-		external []string // Both modules and the head file.
+func statsPassageEntry(p *twee.Passage) string {
+	if pos := p.Position(); pos != "" {
+		return fmt.Sprintf("%q (%s)", p.Name(), pos)
 	}
-	counts struct {
-		passages      uint64 // Count of all passages.
-		storyPassages uint64 // Count of story passages.
-		storyWords    uint64 // Count of story passage "words" (typing measurement style).
-	}
-}
-
-var stats = statistics{}
-
-func statsAddProjectFile(filepath string) {
-	stats.files.project = append(stats.files.project, filepath)
+	return fmt.Sprintf("%q", p.Name())
 }
 
-func statsAddExternalFile(filepath string) {
-	stats.files.external = append(stats.files.external, filepath)
-}
+func statsLog(s *twee.Story, c *config) {
+	var storyPassages, storyWords uint64
+	for _, p := range s.Passages() {
+		if p.IsStoryPassage() {
+			storyPassages++
+			storyWords += p.CountWords()
+		}
+	}
+	unreachable, deadEnds := s.Reachability(s.StartName(c.startName), c.exemptTags)
 
-func statsLog() {
 	log.Print("Statistics")
-	log.Printf("  Total> Passages: %d", stats.counts.passages)
-	log.Printf("  Story> Passages: %d, Words: %d", stats.counts.storyPassages, stats.counts.storyWords)
+	log.Printf("  Total> Passages: %d", len(s.Passages()))
+	log.Printf("  Story> Passages: %d, Words: %d", storyPassages, storyWords)
+	log.Printf("  Unreachable passages: %d", len(unreachable))
+	for _, p := range unreachable {
+		log.Printf("    %s", statsPassageEntry(p))
+	}
+	log.Printf("  Dead-end passages: %d", len(deadEnds))
+	for _, p := range deadEnds {
+		log.Printf("    %s", statsPassageEntry(p))
+	}
 }
 
-func statsLogFiles() {
+func statsLogFiles(s *twee.Story) {
 	log.Println("Processed files (in order)")
-	log.Printf("  Project files: %d", len(stats.files.project))
-	for _, file := range stats.files.project {
+	log.Printf("  Project files: %d", len(s.Files()))
+	for _, file := range s.Files() {
 		log.Printf("    %s", file)
 	}
-	log.Printf("  External files: %d", len(stats.files.external))
-	for _, file := range stats.files.external {
+	log.Printf("  External files: %d", len(s.ExternalFiles()))
+	for _, file := range s.ExternalFiles() {
 		log.Printf("    %s", file)
 	}
 }
@@ -0,0 +1,151 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	// internal packages
+	"github.com/tmedwards/tweego/internal/option"
+	"github.com/tmedwards/tweego/pkg/twee"
+)
+
+// storyFormatMain runs the `format` command, which installs, lists, or removes
+// story formats, then exits.
+func storyFormatMain(args []string) {
+	options := option.NewParser()
+	options.Add("help", "-h|--help")
+	opts, operands, err := options.Parse(args)
+	if err != nil {
+		log.Printf("error: %s", err.Error())
+		usage()
+	}
+	if _, ok := opts["help"]; ok || len(operands) == 0 {
+		usage()
+	}
+
+	switch cmd, operands := operands[0], operands[1:]; cmd {
+	case "install":
+		if len(operands) == 0 {
+			log.Print("error: format install: Story format archives not specified.")
+			usage()
+		}
+		dirname, err := getUserFormatDir(true)
+		if err != nil {
+			log.Fatalf("error: format install: %s", err.Error())
+		}
+		failed := false
+		for _, pathname := range operands {
+			f, err := twee.InstallFormat(pathname, dirname, loadFormats(getFormatSearchDirs(), nil))
+			if err != nil {
+				log.Printf("error: format install: %s", err.Error())
+				failed = true
+				continue
+			}
+			fmt.Printf("Installed %q within %s: %s\n", f.ID(), dirname, describeFormat(f))
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	case "list":
+		formats := loadFormats(getFormatSearchDirs(), nil)
+		if len(formats) == 0 {
+			log.Fatal("error: format list: Story formats not found.")
+		}
+		ids := formats.IDs()
+		sort.Sort(StringsInsensitively(ids))
+		fmt.Println("ID                     Name (Version) [Details]         Directory")
+		fmt.Println("--------------------   ------------------------------   ------------------------------")
+		for _, id := range ids {
+			f := formats[id]
+			fmt.Printf("%-20s   %-30s   %s\n", f.ID(), describeFormat(f), filepath.Dir(f.Filename()))
+		}
+
+	case "remove":
+		if len(operands) == 0 {
+			log.Print("error: format remove: Story format IDs not specified.")
+			usage()
+		}
+		dirname, err := getUserFormatDir(false)
+		if err != nil {
+			log.Fatalf("error: format remove: %s", err.Error())
+		}
+		failed := false
+		for _, id := range operands {
+			if err := removeFormat(dirname, id); err != nil {
+				log.Printf("error: format remove: %s", err.Error())
+				failed = true
+				continue
+			}
+			fmt.Printf("Removed %q from %s.\n", id, dirname)
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	default:
+		log.Printf("error: format: Unknown command %q; must be one of: %q, %q, %q.", cmd, "install", "list", "remove")
+		usage()
+	}
+
+	os.Exit(0)
+}
+
+// getUserFormatDir returns the story format directory within the user's home
+// directory—i.e., the first of the story format directories which exists,
+// elsewise `.storyformats`, which is created if requested.
+func getUserFormatDir(create bool) (string, error) {
+	homeDir, err := userHomeDir()
+	if err != nil {
+		return "", err
+	}
+	for _, baseDirname := range formatDirBasenames {
+		dirname := filepath.Join(homeDir, baseDirname)
+		if info, err := os.Stat(dirname); err == nil && info.IsDir() {
+			return dirname, nil
+		}
+	}
+
+	dirname := filepath.Join(homeDir, ".storyformats")
+	if !create {
+		return "", fmt.Errorf("Story format directory %s not found.", dirname)
+	}
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return "", err
+	}
+	return dirname, nil
+}
+
+// removeFormat removes the story format from the story format directory.  Only
+// story formats within the user's story format directory may be removed.
+func removeFormat(dirname, id string) error {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return fmt.Errorf("Story format ID %q is invalid.", id)
+	}
+	if _, ok := twee.LoadFormats([]string{dirname}, nil)[id]; !ok {
+		return fmt.Errorf("Story format %q not found within %s.", id, dirname)
+	}
+	return os.RemoveAll(filepath.Join(dirname, id))
+}
+
+// describeFormat returns the name and version of the story format, along with
+// its details, if in the Twine 2 style.
+func describeFormat(f *twee.Format) string {
+	if !f.IsTwine2Style() {
+		return "[Twine 1 style]"
+	}
+	desc := fmt.Sprintf("%s (%s)", f.Name(), f.Version())
+	if f.IsProofing() {
+		desc += " [proofing]"
+	}
+	return desc
+}
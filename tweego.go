@@ -9,7 +9,14 @@
 package main
 
 import (
+	// standard packages
+	"bytes"
 	"log"
+	"os"
+	"path/filepath"
+	// internal packages
+	"github.com/tmedwards/tweego/internal/lsp"
+	"github.com/tmedwards/tweego/pkg/twee"
 )
 
 const tweegoName = "tweego"
@@ -20,102 +27,195 @@ func init() {
 }
 
 func main() {
+	// Run the formatter, if requested.
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		formatMain(os.Args[2:])
+	}
+
+	// Manage the story formats, if requested.
+	if len(os.Args) > 1 && os.Args[1] == "format" {
+		storyFormatMain(os.Args[2:])
+	}
+
+	// Start the language server, if requested.
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("error: lsp: %s", err.Error())
+		}
+		return
+	}
+
 	// Create a new config instance.
 	c := newConfig()
 
+	// With machine-readable diagnostics, standard error carries only their
+	// documents, so log everything else to standard output, unless the output
+	// is written there.
+	if c.diagFormat != diagFormatText && c.outFile != "-" {
+		log.SetOutput(os.Stdout)
+	}
+
 	// Build the output and, possibly, log various stats.
 	if c.watchFiles {
 		buildName := relPath(c.outFile)
 		paths := append(c.sourcePaths, c.modulePaths...)
-		watchFilesystem(paths, c.outFile, func() {
+
+		// Start the live-reload development server, if enabled.
+		var srv *devServer
+		if c.serveFiles {
+			srv = newDevServer(c.outFile)
+			if err := srv.start(c.serveAddr); err != nil {
+				log.Fatalf("error: serve: %s", err.Error())
+			}
+		}
+
+		cache := twee.NewCache()
+		watchFilesystem(paths, c.outFile, c.watchOpts, func(rescan bool) {
 			log.Printf("BUILDING: %s", buildName)
-			buildOutput(c)
+			if rescan {
+				cache.Rescan()
+			}
+			if _, err := build(c, cache); err != nil {
+				// Keep watching.  When compiling HTML or proofing copies,
+				// replace the output with an error page, elsewise keep the
+				// previous output.
+				if c.outMode != twee.OutModeHTML && c.outMode != twee.OutModeProofing {
+					log.Printf("BUILD FAILED: %s (previous output kept)", buildName)
+					return
+				}
+				if _, err := fileWriteAll(c.outFile, newErrorPage(err, c.encoding)); err != nil {
+					log.Printf("error: %s", err.Error())
+					return
+				}
+				log.Printf("BUILD FAILED: %s (error page written)", buildName)
+			}
+			if srv != nil {
+				srv.reload()
+			}
 		})
 	} else {
-		buildOutput(c)
+		s, err := build(c, nil)
+		if err != nil {
+			if _, ok := err.(*twee.FormatUnavailableError); ok && c.diagFormat == diagFormatText {
+				usageFormats(c.formats)
+			}
+			os.Exit(1)
+		}
 
 		// Logging.
+		if s == nil {
+			return
+		}
 		if c.logFiles {
 			log.Println()
-			statsLogFiles()
+			statsLogFiles(s)
 			log.Println()
 		}
 		if c.logStats {
 			if !c.logFiles {
 				log.Println()
 			}
-			statsLog()
+			statsLog(s, c)
 			log.Println()
 		}
 	}
 }
 
-func buildOutput(c *config) *story {
-	// Get the source and module paths.
-	sourcePaths := getFilenames(c.sourcePaths, c.outFile)
-	modulePaths := getFilenames(c.modulePaths, c.outFile)
+// build builds the output and reports the diagnostics of the build in the
+// configured format.  The build cache is optional.
+func build(c *config, cache *twee.Cache) (*twee.Story, error) {
+	if c.diagFormat == diagFormatText {
+		s, err := buildOutput(c, cache, logDiagnostic)
+		if err != nil {
+			logErrors(err)
+		}
+		return s, err
+	}
 
-	// Create a new story instance and load the source files.
-	s := newStory()
-	s.load(sourcePaths, c)
+	// NOTE: Each build's diagnostics are a complete document, so they include
+	// those of the story formats.
+	diags := append([]*twee.Diagnostic(nil), c.formatDiags...)
+	s, err := buildOutput(c, cache, func(d *twee.Diagnostic) {
+		diags = append(diags, d)
+	})
+	if err != nil {
+		diags = append(diags, twee.ErrorDiagnostics(err)...)
+	}
+	if err := writeDiagnostics(os.Stderr, c.diagFormat, diags); err != nil {
+		log.Printf("error: diagnostics: %s", err.Error())
+	}
+	return s, err
+}
 
-	// Finalize the config with values from the `StoryData` passage, if any.
-	c.mergeStoryConfig(s)
+// buildOutput builds the output.  The output file is only written if the
+// build succeeds.  The build cache and reporter are optional.
+func buildOutput(c *config, cache *twee.Cache, report func(*twee.Diagnostic)) (*twee.Story, error) {
+	opts := c.tweeOptions(cache)
+	opts.Report = report
 
-	// Write the output.
-	switch c.outMode {
-	case outModeTwee3, outModeTwee1:
-		// Write out the project as Twee source.
-		if _, err := fileWriteAll(c.outFile, alignRecordSeparators(s.toTwee(c.outMode))); err != nil {
-			log.Fatalf(`error: %s`, err.Error())
-		}
-	case outModeTwine2Archive:
-		// Write out the project as Twine 2 archived HTML.
-		if _, err := fileWriteAll(c.outFile, s.toTwine2Archive(c.startName)); err != nil {
-			log.Fatalf(`error: %s`, err.Error())
-		}
-	case outModeTwine1Archive:
-		// Write out the project as Twine 1 archived HTML.
-		if _, err := fileWriteAll(c.outFile, s.toTwine1Archive(c.startName)); err != nil {
-			log.Fatalf(`error: %s`, err.Error())
-		}
-	default:
-		// Basic sanity checks.
-		if !s.has(c.startName) {
-			log.Fatalf("error: Starting passage %q not found.", c.startName)
-		}
-		if (s.format.isTwine1Style() || s.name == "") && !s.has("StoryTitle") {
-			log.Fatal(`error: Special passage "StoryTitle" not found.`)
-		}
+	// Decompile each story of the library archives, if enabled.
+	if c.allStories {
+		return nil, buildAllStories(c, opts)
+	}
 
-		if s.format.isTwine2Style() {
-			// Write out the project as Twine 2 compiled HTML.
-			if _, err := fileWriteAll(
-				c.outFile,
-				modifyHead(
-					s.toTwine2HTML(c.startName),
-					modulePaths,
-					c.headFile,
-					c.encoding,
-				),
-			); err != nil {
-				log.Fatalf(`error: %s`, err.Error())
-			}
-		} else {
-			// Write out the project as Twine 1 compiled HTML.
-			if _, err := fileWriteAll(
-				c.outFile,
-				modifyHead(
-					s.toTwine1HTML(c.startName),
-					modulePaths,
-					c.headFile,
-					c.encoding,
-				),
-			); err != nil {
-				log.Fatalf(`error: %s`, err.Error())
-			}
+	// Load the source files into a new story instance.
+	s, err := twee.Load(c.sourcePaths, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeStory(c, s, opts, c.outFile); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// writeStory compiles the story, writing the output to the named file or,
+// when decompiling into a project tree, directory.
+func writeStory(c *config, s *twee.Story, opts *twee.Options, outFile string) error {
+	// Decompile the story into a project tree, if enabled.
+	if c.splitBy != "" {
+		files, err := s.DecompileTree(opts)
+		if err != nil {
+			return err
 		}
+		return writeTree(outFile, files)
 	}
 
-	return s
+	// Extract the media passages when decompiling to a file, so that they may
+	// be written alongside it as media files, rather than as data URIs.
+	var media []*twee.TreeFile
+	if (c.outMode == twee.OutModeTwee3 || c.outMode == twee.OutModeTwee1) && outFile != "-" {
+		media = s.ExtractMedia(opts)
+	}
+
+	// Compile the story.
+	var output bytes.Buffer
+	if err := s.Compile(&output, opts); err != nil {
+		return err
+	}
+
+	// Check the story format against the lockfile, or update it.
+	if err := checkLockFile(c, s, opts.Report); err != nil {
+		return err
+	}
+
+	// Check that writing the media files would not overwrite differing ones.
+	if err := checkTreeConflicts(filepath.Dir(outFile), media); err != nil {
+		return err
+	}
+
+	// Write the output.
+	if _, err := fileWriteAll(outFile, output.Bytes()); err != nil {
+		return err
+	}
+	return writeTree(filepath.Dir(outFile), media)
+}
+
+// logErrors logs each of the individual errors of err.
+func logErrors(err error) {
+	for _, err := range twee.FlattenErrors(err) {
+		log.Printf("error: %s", err.Error())
+	}
 }
@@ -12,6 +12,8 @@ import (
 	"math"
 	"os"
 	"sort"
+	// internal packages
+	"github.com/tmedwards/tweego/pkg/twee"
 	// external packages
 	"github.com/paulrosania/go-charset/charset"
 )
@@ -25,43 +27,112 @@ func usage() {
 
 	fmt.Fprintf(os.Stderr, `
 Usage: %s [options] sources...
+       %s fmt [--check] [-c SET] sources...
+       %s lsp
 
   sources                  Input sources (repeatable); may consist of supported
                              files and/or directories to recursively search for
                              such files.
 
 Options:
+      --all-stories        Decompile each story of the Twine 2 library archives
+                             into its own directory within the output
+                             directory; requires a decompile option.
   -a, --archive-twine2     Output Twine 2 archive, instead of compiled HTML.
       --archive-twine1     Output Twine 1 archive, instead of compiled HTML.
+      --archive-json       Output Twine 2 story data as Twison-style JSON,
+                             instead of compiled HTML.
   -c SET, --charset=SET    Name of the input character set (default: "utf-8",
                              fallback: %q).
+      --config=FILE        Name of the project configuration file (default:
+                             "tweego.json" or "tweego.toml" within the working
+                             directory, if either exists).
+      --diagnostics-format=FMT
+                           Format of the warnings and errors; one of: "text",
+                             "json", "sarif" (default: %q).
   -d, --decompile-twee3    Output Twee 3 source code, instead of compiled HTML.
       --decompile-twee1    Output Twee 1 source code, instead of compiled HTML.
+      --decompile-split=BY Output a Twee 3 project tree into the output
+                             directory, instead of compiled HTML; one Twee
+                             file per "passage" or per "tag" set, plus script,
+                             stylesheet, font, and media files.
+      --exclude-source=SRC Sources (repeatable) to exclude; may consist of files
+                             and/or directories whose files are excluded.
+      --exclude-tag=TAG    Tag (repeatable) whose passages are excluded from
+                             the story.
+      --exempt-tag=TAG     Tag (repeatable) exempting passages from the
+                             unreachable and dead-end passage reports.
   -f NAME, --format=NAME   ID of the story format (default: %q).
+      --graph-dot          Output the passage link graph as Graphviz DOT,
+                             instead of compiled HTML.
+      --graph-json         Output the passage link graph as JSON, instead of
+                             compiled HTML.
   -h, --help               Print this help, then exit.
       --head=FILE          Name of the file whose contents will be appended
                              as-is to the <head> element of the compiled HTML.
       --list-charsets      List the supported input character sets, then exit.
       --list-formats       List the available story formats, then exit.
+      --list-stories       List the stories of the Twine 2 HTML sources (e.g.,
+                             library archives), then exit.
       --log-files          Log the processed input files.
-  -l, --log-stats          Log various story statistics.
+  -l, --log-stats          Log various story statistics, including the
+                             unreachable and dead-end passage reports.
   -m SRC, --module=SRC     Module sources (repeatable); may consist of supported
                              files and/or directories to recursively search for
                              such files.
       --no-trim            Do not trim whitespace surrounding passages.
   -o FILE, --output=FILE   Name of the output file (default: %q).
+  -p, --proof              Output a proofing copy, compiled with a proofing
+                             format, instead of compiled HTML.
+      --proofing-format=NAME
+                           ID or name of the proofing format (default:
+                             Paperthin, if available).
+      --profile=NAME       Name of the project configuration file profile to
+                             build.
+      --serve              Start serve mode; watch mode, plus a live-reload
+                             development server for the compiled HTML.
+      --serve-addr=ADDR    Address of the development server (default: %q).
   -s NAME, --start=NAME    Name of the starting passage (default: the passage
                              set by the story data, elsewise %q).
+      --story=NAME         Name or IFID of the story to load from Twine 2
+                             library archives, which contain several.
+      --strict-links       Treat broken passage links as errors, rather than
+                             warnings.
+      --strict-lock        Treat story formats differing from the one recorded
+                             within the lockfile as errors, rather than
+                             warnings.
   -t, --test               Compile in test mode; only for story formats in the
                              Twine 2 style.
       --twee2-compat       Enable Twee2 source compatibility mode; files with
                              the .tw2 or .twee2 extensions automatically have
                              compatibility mode enabled.
+      --update-lock        Record the story format the build resolves to within
+                             the lockfile (tweego.lock).
   -v, --version            Print version information, then exit.
   -w, --watch              Start watch mode; watch input sources for changes,
                              rebuilding the output as necessary.
+      --watch-debounce=DUR Quiet period after a change before rebuilding in
+                             watch mode (default: %q).
+      --watch-ignore=PAT   Pattern of paths to ignore in watch mode
+                             (repeatable); in addition to the defaults.
+      --watch-poll         Poll for changes in watch mode, rather than using
+                             native filesystem notifications.
 
-`, tweegoName, fallbackCharset, defaultFormatID, outFile, defaultStartName)
+Commands:
+  fmt                      Rewrite Twee 3 source files in canonical form; with
+                             --check, only list the files which would change,
+                             exiting with a non-zero status if any.
+  format install ARCHIVE   Install the story format within the zip file or
+                             directory into the user's story format
+                             directory (~/.storyformats, by default).
+  format list              List the available story formats and their
+                             directories.
+  format remove ID         Remove the story format from the user's story
+                             format directory.
+  lsp                      Start a Language Server Protocol server for Twee
+                             sources, communicating over stdin and stdout.
+
+`, tweegoName, tweegoName, tweegoName, twee.FallbackCharset, defaultDiagFormat, twee.DefaultFormatID, outFile, defaultServeAddr, twee.DefaultStartName, defaultWatchDebounce.String())
 	os.Exit(1)
 }
 
@@ -90,22 +161,22 @@ func usageCharsets() {
 }
 
 // formats the list of supported story formats somewhat nicely for the user
-func usageFormats(formats storyFormatsMap) {
+func usageFormats(formats twee.Formats) {
 	fmt.Fprintln(os.Stderr)
-	if formats.isEmpty() {
+	if len(formats) == 0 {
 		fmt.Fprintln(os.Stderr, "Story formats not found.")
 	} else {
-		ids := formats.ids()
+		ids := formats.IDs()
 		sort.Sort(StringsInsensitively(ids))
 		fmt.Fprintln(os.Stderr, "Available formats:")
 		fmt.Fprintln(os.Stderr, "  ID                     Name (Version) [Details]")
 		fmt.Fprintln(os.Stderr, "  --------------------   ------------------------------")
 		for _, id := range ids {
 			f := formats[id]
-			fmt.Fprintf(os.Stderr, "  %-20s", f.id)
-			if f.isTwine2Style() {
-				fmt.Fprintf(os.Stderr, "   %s (%s)", f.name, f.version)
-				if f.proofing {
+			fmt.Fprintf(os.Stderr, "  %-20s", f.ID())
+			if f.IsTwine2Style() {
+				fmt.Fprintf(os.Stderr, "   %s (%s)", f.Name(), f.Version())
+				if f.IsProofing() {
 					fmt.Fprint(os.Stderr, " [proofing]")
 				}
 			}
@@ -0,0 +1,274 @@
+//go:build linux
+// +build linux
+
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR
+
+// inotifyWatcher is the native watcher backend for Linux, using inotify.
+type inotifyWatcher struct {
+	fd      int
+	dirs    map[int32]*inotifyDir // Map of watch descriptors to directories.
+	ignored func(string) bool
+	events  chan watchEvent
+	errs    chan error
+}
+
+// inotifyDir is a watched directory.
+type inotifyDir struct {
+	path      string
+	recursive bool            // Whether the directory is watched recursively.
+	names     map[string]bool // Names of the entries to watch, if not recursive.
+}
+
+// watchNative is the native watcher backend.
+func watchNative(pathnames []string, ignored func(string) bool) (<-chan watchEvent, <-chan error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		dirs:    make(map[int32]*inotifyDir),
+		ignored: ignored,
+		events:  make(chan watchEvent),
+		errs:    make(chan error),
+	}
+
+	// Watch the specified paths—recursively for directories and, for files,
+	// via their parent directories.
+	for _, pathname := range pathnames {
+		absolute, err := filepath.Abs(pathname)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, nil, err
+		}
+		info, err := os.Stat(absolute)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, nil, err
+		}
+		if info.IsDir() {
+			err = w.addRecursive(absolute)
+		} else {
+			err = w.addFile(absolute)
+		}
+		if err != nil {
+			syscall.Close(fd)
+			return nil, nil, err
+		}
+	}
+
+	go w.readEvents()
+
+	return w.events, w.errs, nil
+}
+
+// addDir watches the directory, returning its entry.
+func (w *inotifyWatcher) addDir(dirname string) (*inotifyDir, error) {
+	wd, err := syscall.InotifyAddWatch(w.fd, dirname, inotifyMask)
+	if err != nil {
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: dirname, Err: err}
+	}
+
+	// NOTE: Watching a directory again yields its existing descriptor.
+	dir, ok := w.dirs[int32(wd)]
+	if !ok {
+		dir = &inotifyDir{path: dirname}
+		w.dirs[int32(wd)] = dir
+	}
+	return dir, nil
+}
+
+// addRecursive watches the directory and all of its subdirectories, save for
+// those which are ignored.
+func (w *inotifyWatcher) addRecursive(root string) error {
+	return filepath.Walk(root, func(pathname string, info os.FileInfo, err error) error {
+		if err != nil {
+			if pathname == root {
+				return err
+			}
+			// Subdirectories may be removed while walking, so failure is okay.
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if pathname != root && w.ignored(pathname) {
+			return filepath.SkipDir
+		}
+
+		dir, err := w.addDir(pathname)
+		if err != nil {
+			return err
+		}
+		dir.recursive = true
+		return nil
+	})
+}
+
+// addFile watches the file via its parent directory.
+func (w *inotifyWatcher) addFile(filename string) error {
+	dir, err := w.addDir(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	if dir.names == nil {
+		dir.names = make(map[string]bool)
+	}
+	dir.names[filepath.Base(filename)] = true
+	return nil
+}
+
+// removeTree stops watching the directory and all of its subdirectories.
+func (w *inotifyWatcher) removeTree(dirname string) {
+	prefix := dirname + string(filepath.Separator)
+	for wd, dir := range w.dirs {
+		if dir.path == dirname || strings.HasPrefix(dir.path, prefix) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// rewatch watches the subdirectories of the recursively watched directories
+// which are not yet watched—e.g., those created while events were dropped.
+func (w *inotifyWatcher) rewatch() {
+	recursive := make(map[string]bool)
+	for _, dir := range w.dirs {
+		if dir.recursive {
+			recursive[dir.path] = true
+		}
+	}
+	for dirname := range recursive {
+		if recursive[filepath.Dir(dirname)] {
+			continue // Walked along with its parent.
+		}
+		if err := w.addRecursive(dirname); err != nil && !os.IsNotExist(err) {
+			w.errs <- err
+		}
+	}
+}
+
+func (w *inotifyWatcher) readEvents() {
+	var (
+		buf     = make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+		pending *watchEvent // Move awaiting its other half.
+		cookie  uint32      // Cookie of the pending move.
+	)
+
+	// A move whose other half is not watched is either a removal or a creation.
+	flush := func() {
+		if pending != nil {
+			w.events <- *pending
+			pending = nil
+		}
+	}
+
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			w.errs <- os.NewSyscallError("read", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were dropped, so watch any directories which may
+				// have been created in the meantime and request a rescan.
+				flush()
+				w.rewatch()
+				w.events <- watchEvent{op: watchOverflow}
+				continue
+			}
+
+			dir, ok := w.dirs[raw.Wd]
+			if !ok {
+				continue
+			}
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, raw.Wd)
+				continue
+			}
+			if name == "" || !dir.recursive && !dir.names[name] {
+				continue
+			}
+
+			pathname := filepath.Join(dir.path, name)
+			if w.ignored(pathname) {
+				continue
+			}
+
+			isDir := raw.Mask&syscall.IN_ISDIR != 0
+			switch {
+			case raw.Mask&syscall.IN_CREATE != 0:
+				flush()
+				if isDir && dir.recursive {
+					if err := w.addRecursive(pathname); err != nil {
+						w.errs <- err
+					}
+				}
+				w.events <- watchEvent{op: watchCreate, path: pathname, isDir: isDir}
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				flush()
+				w.events <- watchEvent{op: watchWrite, path: pathname}
+			case raw.Mask&syscall.IN_DELETE != 0:
+				flush()
+				w.events <- watchEvent{op: watchRemove, path: pathname, isDir: isDir}
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				flush()
+				if isDir {
+					w.removeTree(pathname)
+				}
+				pending = &watchEvent{op: watchRemove, path: pathname, isDir: isDir}
+				cookie = raw.Cookie
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				if isDir && dir.recursive {
+					if err := w.addRecursive(pathname); err != nil {
+						w.errs <- err
+					}
+				}
+				if pending != nil && cookie == raw.Cookie {
+					w.events <- watchEvent{op: watchRename, path: pathname, oldPath: pending.path, isDir: isDir}
+					pending = nil
+				} else {
+					flush()
+					w.events <- watchEvent{op: watchCreate, path: pathname, isDir: isDir}
+				}
+			}
+		}
+
+		// Moves are paired within the same read, so any unpaired move left
+		// over is a removal.
+		flush()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+// watchNative is the native watcher backend, which is unavailable on this
+// platform.
+func watchNative(pathnames []string, ignored func(string) bool) (<-chan watchEvent, <-chan error, error) {
+	return nil, nil, errWatchNativeUnsupported
+}
@@ -0,0 +1,99 @@
+/*
+	Copyright © 2014–2020 Thomas Michael Edwards. All rights reserved.
+	Use of this source code is governed by a Simplified BSD License which
+	can be found in the LICENSE file.
+*/
+
+package main
+
+import (
+	// standard packages
+	"os"
+	"path/filepath"
+	"time"
+	// external packages
+	"github.com/radovskyb/watcher"
+)
+
+const watchPollRate = time.Second
+
+// watchPolling is the polling watcher backend, which is available everywhere.
+func watchPolling(pathnames []string, ignored func(string) bool) (<-chan watchEvent, <-chan error, error) {
+	var (
+		events = make(chan watchEvent)
+		errs   = make(chan error)
+		w      = watcher.New()
+	)
+
+	// Only notify on certain events.
+	w.FilterOps(
+		watcher.Create,
+		watcher.Write,
+		watcher.Remove,
+		watcher.Rename,
+		watcher.Move,
+	)
+
+	// Skip ignored paths.
+	//
+	// NOTE: When recursively listing, the hook's error is returned to the
+	// directory walker, so returning `filepath.SkipDir` for ignored directories
+	// skips their contents entirely, rather than merely their own entries.
+	w.AddFilterHook(func(info os.FileInfo, fullPath string) error {
+		if !ignored(fullPath) {
+			return nil
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return watcher.ErrSkip
+	})
+
+	// Recursively watch the specified paths for changes.
+	for _, pathname := range pathnames {
+		if err := w.AddRecursive(pathname); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Start a goroutine to translate the events.
+	go func() {
+		for {
+			select {
+			case event := <-w.Event:
+				if event.FileInfo == nil {
+					continue
+				}
+
+				e := watchEvent{path: event.Path, isDir: event.IsDir()}
+				switch event.Op {
+				case watcher.Create:
+					e.op = watchCreate
+				case watcher.Write:
+					e.op = watchWrite
+				case watcher.Remove:
+					e.op = watchRemove
+				case watcher.Move, watcher.Rename:
+					e.op = watchRename
+					e.oldPath = event.OldPath
+				default:
+					continue
+				}
+				events <- e
+			case err := <-w.Error:
+				errs <- err
+			case <-w.Closed:
+				return
+			}
+		}
+	}()
+
+	// Start polling.
+	go func() {
+		if err := w.Start(watchPollRate); err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs, nil
+}